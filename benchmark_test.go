@@ -0,0 +1,218 @@
+package glog
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// discardChannel 只格式化,不输出
+type discardChannel struct {
+	BaseChannel
+}
+
+func newDiscardChannel(opts ...ChannelOption) *discardChannel {
+	c := &discardChannel{}
+	c.Init(NewChannelOptions(opts...))
+	return c
+}
+
+func (c *discardChannel) Name() string {
+	return "discard"
+}
+
+func (c *discardChannel) Write(e *Entry) {
+	_ = c.Format(e)
+}
+
+func newBenchLogger(lv Level, channels ...Channel) Logger {
+	conf := NewConfig()
+	conf.Level = lv
+	conf.AddTags(map[string]string{"env": "bench"})
+	conf.AddChannels(channels...)
+	return NewLogger(conf)
+}
+
+func BenchmarkDisabledLevel(b *testing.B) {
+	l := newBenchLogger(InfoLevel, newDiscardChannel())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Debug(nil, "disabled")
+	}
+}
+
+func BenchmarkDisabledLevelf(b *testing.B) {
+	l := newBenchLogger(InfoLevel, newDiscardChannel())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Debugf(nil, "disabled")
+	}
+}
+
+func BenchmarkLogTypedFields(b *testing.B) {
+	l := newBenchLogger(TraceLevel, newDiscardChannel())
+	err := errors.New("fail")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Info(nil, "request done",
+			Int("status", 200),
+			String("method", "GET"),
+			Float64("cost", 1.5),
+			Bool("cache", true),
+			Any("err", err))
+	}
+}
+
+func BenchmarkLogTypedFieldsNoCaller(b *testing.B) {
+	conf := NewConfig()
+	conf.DisableCaller = true
+	conf.AddChannels(newDiscardChannel())
+	l := NewLogger(conf)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Info(nil, "request done", Int("status", 200), String("method", "GET"))
+	}
+}
+
+func BenchmarkLogSharedFormatter(b *testing.B) {
+	// 多个Channel共用Formatter,只格式化一次
+	l := newBenchLogger(TraceLevel, newDiscardChannel(), newDiscardChannel(), newDiscardChannel())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Info(nil, "request done", Int("status", 200), String("method", "GET"))
+	}
+}
+
+func BenchmarkLogJson(b *testing.B) {
+	f, err := NewJsonFormatter("time=%d{yyyy-MM-ddTHH:mm:ss} level=%p msg=%m")
+	if err != nil {
+		b.Fatal(err)
+	}
+	l := newBenchLogger(TraceLevel, newDiscardChannel(WithFormatter(f)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Info(nil, "request done", Int("status", 200), String("method", "GET"))
+	}
+}
+
+func TestEntryReset(t *testing.T) {
+	c := newDiscardChannel()
+	l := newBenchLogger(TraceLevel, c)
+	l.Info(nil, "first", Int("status", 200))
+
+	e := NewEntry(l)
+	if e.Text != "" || len(e.Fields) != 0 || e.Context != nil || e.outputs.size != 0 {
+		t.Errorf("entry not reset, %+v", e.Text)
+	}
+	e.Free()
+}
+
+func TestDisabledLevelAllocs(t *testing.T) {
+	l := newBenchLogger(InfoLevel, newDiscardChannel())
+	allocs := testing.AllocsPerRun(100, func() {
+		l.Debug(nil, "disabled")
+	})
+	if allocs != 0 {
+		t.Errorf("disabled level should not alloc, got %v", allocs)
+	}
+}
+
+// nopChannel 不做任何处理,用于测试队列本身的开销
+type nopChannel struct {
+	BaseChannel
+}
+
+func (c *nopChannel) Name() string {
+	return "nop"
+}
+
+func (c *nopChannel) Write(e *Entry) {
+}
+
+func BenchmarkAsyncQueue(b *testing.B) {
+	for _, queue := range []struct {
+		name string
+		typ  QueueType
+	}{{"linked", QueueLinked}, {"ring", QueueRing}} {
+		for _, producers := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("%s/producers=%d", queue.name, producers), func(b *testing.B) {
+				benchmarkAsyncQueue(b, queue.typ, producers)
+			})
+		}
+	}
+}
+
+// benchmarkAsyncQueue 测试多个goroutine同时写入时的吞吐量和写入延迟
+func benchmarkAsyncQueue(b *testing.B, queue QueueType, producers int) {
+	nc := &nopChannel{}
+	nc.Init(NewChannelOptions())
+	c := NewAsyncChannelWithOptions([]Channel{nc}, AsyncOptions{
+		LogMax:   1 << 14,
+		Overflow: OverflowBlock,
+		Queue:    queue,
+	})
+	c.Open()
+
+	latencies := make([]time.Duration, b.N)
+	wg := sync.WaitGroup{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := p; i < b.N; i += producers {
+				e := NewEntry(nil)
+				e.Level = InfoLevel
+				start := time.Now()
+				c.Write(e)
+				latencies[i] = time.Since(start)
+				e.Free()
+			}
+		}(p)
+	}
+	wg.Wait()
+	b.StopTimer()
+	c.Close()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[b.N*99/100].Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(latencies[b.N*999/1000].Nanoseconds()), "p999-ns")
+}
+
+func benchmarkFileChannel(b *testing.B, opts ...ChannelOption) {
+	dir, err := ioutil.TempDir("", "glog_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := NewFileChannel(append([]ChannelOption{WithFile(filepath.Join(dir, "bench.log"))}, opts...)...)
+	l := newBenchLogger(InfoLevel, c)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Info(nil, "file channel benchmark")
+	}
+	b.StopTimer()
+	l.Stop()
+}
+
+func BenchmarkFileChannel(b *testing.B) {
+	benchmarkFileChannel(b)
+}
+
+func BenchmarkFileChannelBuffered(b *testing.B) {
+	benchmarkFileChannel(b, WithBufferSize(64*1024))
+}
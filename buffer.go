@@ -9,6 +9,9 @@ import (
 
 // smallBufferSize is an initial allocation minimal capacity.
 const smallBufferSize = 64
+
+// maxPooledBufferSize 超过该大小的Buffer不再放回缓存,避免长期占用内存
+const maxPooledBufferSize = 64 * 1024
 const maxInt = int(^uint(0) >> 1)
 
 var gBufferPool = sync.Pool{
@@ -29,10 +32,18 @@ type Buffer struct {
 
 // Free 将Buffer放入缓存中
 func (b *Buffer) Free() {
-	b.buf = b.buf[:]
+	if cap(b.buf) > maxPooledBufferSize {
+		return
+	}
+	b.buf = b.buf[:0]
 	gBufferPool.Put(b)
 }
 
+// Reset 清空数据,保留内存
+func (b *Buffer) Reset() {
+	b.buf = b.buf[:0]
+}
+
 // Bytes 返回Bytes数据
 func (b *Buffer) Bytes() []byte {
 	return b.buf
@@ -78,13 +89,13 @@ func (b *Buffer) Grow(n int) {
 	}
 
 	if b.buf == nil && n <= smallBufferSize {
-		b.buf = make([]byte, n, smallBufferSize)
+		b.buf = make([]byte, 0, smallBufferSize)
 		return
 	}
 
 	c := cap(b.buf)
-	buf := make([]byte, 2*c+n)
-	copy(buf, b.buf[:])
+	buf := make([]byte, m, 2*c+n)
+	copy(buf, b.buf)
 	b.buf = buf
 }
 
@@ -101,6 +112,10 @@ func (b *Buffer) AppendString(s string) {
 	b.buf = append(b.buf, s...)
 }
 
+func (b *Buffer) AppendBytes(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
 func (b *Buffer) AppendInt(i int64) {
 	b.buf = strconv.AppendInt(b.buf, i, 10)
 }
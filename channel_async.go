@@ -1,6 +1,13 @@
 package glog
 
-import "sync"
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 const (
 	statusNone    = 0 // 尚未运行
@@ -8,16 +15,81 @@ const (
 	statusStop    = 2 // 已经结束
 )
 
+const (
+	// OverflowDropNewest 丢弃新写入的日志,默认策略
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest 丢弃队列中最旧的日志
+	OverflowDropOldest
+	// OverflowBlock 阻塞直到队列有空间
+	OverflowBlock
+	// OverflowBlockTimeout 阻塞等待,超时后丢弃新日志
+	OverflowBlockTimeout
+)
+
+// OverflowPolicy 异步队列满时的处理策略
+type OverflowPolicy int
+
+const (
+	// QueueLinked 使用互斥锁保护的链表队列,默认
+	QueueLinked QueueType = iota
+	// QueueRing 使用无锁的环形队列,多个goroutine同时写日志时竞争更小
+	// 不支持OverflowDropOldest,此时自动使用QueueLinked,不低于NoDropLevel的日志会阻塞等待
+	QueueRing
+)
+
+// QueueType 异步队列的实现方式
+type QueueType int
+
+// ringWaitInterval 无锁队列满时,阻塞等待的重试间隔
+const ringWaitInterval = time.Microsecond * 100
+
+// AsyncOptions 异步队列配置
+type AsyncOptions struct {
+	LogMax         int            // 最大缓存日志数
+	Overflow       OverflowPolicy // 队列满时的处理策略
+	Timeout        time.Duration  // OverflowBlockTimeout时的最长等待时间
+	NoDropLevel    Level          // 不低于该级别的日志不会被丢弃,即使超过LogMax,默认PanicLevel
+	ReportInterval time.Duration  // 定期以Warn日志汇报丢弃数量,0则不汇报
+	Queue          QueueType      // 队列实现方式
+}
+
 // NewAsyncChannel 创建异步队列
 func NewAsyncChannel(channels []Channel, logMax int) Channel {
+	return NewAsyncChannelWithOptions(channels, AsyncOptions{LogMax: logMax})
+}
+
+// NewAsyncChannelWithOptions 通过配置创建异步队列
+func NewAsyncChannelWithOptions(channels []Channel, o AsyncOptions) Channel {
+	return newAsyncChannel("async", channels, o)
+}
+
+func newAsyncChannel(name string, channels []Channel, o AsyncOptions) *asyncChannel {
+	if o.LogMax <= 0 {
+		o.LogMax = DefaultMax
+	}
 	c := &asyncChannel{
+		name:   name,
 		status: statusNone,
-		logMax: logMax,
+		logMax: o.LogMax,
+		opts:   o,
+		done:   make(chan struct{}),
+	}
+	c.level = TraceLevel
+	c.BaseChannel.name = name
+	// 无锁队列不能删除最旧的日志
+	if o.Queue == QueueRing && o.Overflow == OverflowDropOldest {
+		c.opts.Queue = QueueLinked
+	}
+	if c.opts.Queue == QueueRing {
+		c.ring = NewRingQueue(o.LogMax)
+		c.wakeup = make(chan struct{}, 1)
 	}
 	c.cond = sync.NewCond(&c.mux)
+	c.notFull = sync.NewCond(&c.mux)
 	for _, channel := range channels {
 		if bc, ok := channel.(BatchChannel); ok {
 			c.batches = append(c.batches, bc)
+			c.batchers = append(c.batchers, newBatcher(bc))
 		} else {
 			c.channels = append(c.channels, channel)
 		}
@@ -26,127 +98,526 @@ func NewAsyncChannel(channels []Channel, logMax int) Channel {
 	return c
 }
 
-// asyncChannel 异步队列,超过LogMax的消息会被丢弃
-// 如果Channel实现了Batch接口,则会调用批量发送接口
+// asyncChannel 异步队列,超过LogMax时按照Overflow策略处理
+// 如果Channel实现了Batch接口,则会累积后调用批量发送接口
 type asyncChannel struct {
 	BaseChannel
-	channels []Channel
-	batches  []BatchChannel
-	mux      sync.Mutex
-	cond     *sync.Cond
-	queue    Queue
-	status   int
-	logMax   int
+	name       string
+	channels   []Channel
+	batches    []BatchChannel
+	batchers   []*batcher
+	mux        sync.Mutex
+	cond       *sync.Cond // 通知消费者
+	notFull    *sync.Cond // 通知阻塞的生产者
+	queue      Queue
+	ring       *RingQueue    // 非nil时使用无锁队列,queue不再使用
+	sleeping   int32         // 无锁队列模式下worker是否在等待唤醒
+	writers    int32         // 无锁队列模式下正在写入的生产者数,退出时等待写入完成
+	wakeup     chan struct{} // 无锁队列模式下唤醒worker
+	status     int32
+	logMax     int
+	opts       AsyncOptions
+	dropped    [TraceLevel + 1]uint64 // 按级别统计丢弃数量
+	reported   [TraceLevel + 1]uint64 // 上次汇报时的丢弃数量
+	lastReport time.Time              // 上次汇报时间
+	tick       bool                   // 定时器触发,检查汇报和Linger
+	waiters    []chan error           // 等待队列清空的Sync调用
+	quit       chan struct{}          // 通知定时器goroutine退出
+	done       chan struct{}          // worker退出或者未启动就关闭时关闭
 }
 
 func (c *asyncChannel) Name() string {
-	return "async"
+	return c.name
+}
+
+// setup 注册丢弃数和队列长度统计
+func (c *asyncChannel) setup(name string, conf *Config) {
+	c.BaseChannel.setup(name, conf)
+	m := conf.Metrics
+	for lv := range c.dropped {
+		lv := lv
+		m.CounterFunc("glog_async_dropped_total", "Number of log entries dropped by the async queue.", func() float64 {
+			return float64(c.Dropped(Level(lv)))
+		}, "queue", name, "level", strings.ToLower(Level(lv).String()))
+	}
+	m.GaugeFunc("glog_async_queue_length", "Number of log entries waiting in the async queue.", func() float64 {
+		return float64(c.Len())
+	}, "queue", name)
+}
+
+// Len 返回队列中等待处理的日志数
+func (c *asyncChannel) Len() int {
+	if c.ring != nil {
+		return c.ring.Len()
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.queue.Len()
 }
 
 func (c *asyncChannel) Open() error {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	if c.status == statusNone {
-		c.status = statusRunning
+	if c.getStatus() == statusNone {
+		c.setStatus(statusRunning)
+		c.quit = make(chan struct{})
+		c.lastReport = time.Now()
 		go c.Run()
+		if interval := c.tickInterval(); interval > 0 {
+			go c.runTicker(interval, c.quit)
+		}
 	}
 	return nil
 }
 
+// Close 等待队列中的日志全部写入后关闭
 func (c *asyncChannel) Close() error {
+	return c.closeContext(context.Background())
+}
+
+// closeContext 通知worker退出并等待,超时则返回错误,worker退出时会关闭所有Channel
+func (c *asyncChannel) closeContext(ctx context.Context) error {
 	c.mux.Lock()
-	defer c.mux.Unlock()
-	if c.status == statusRunning {
-		c.status = statusStop
-		c.cond.Signal()
+	status := c.getStatus()
+	if status == statusRunning {
+		c.setStatus(statusStop)
+		close(c.quit)
+		c.notify()
+		c.notFull.Broadcast()
+	} else if status == statusNone {
+		c.setStatus(statusStop)
 	}
+	c.mux.Unlock()
+
+	if status == statusNone {
+		// 没有启动worker,直接关闭,之后的Close和Sync不再等待
+		c.closeChannels()
+		close(c.done)
+		return nil
+	}
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
+// Sync 等待当前队列中的日志全部写入
+func (c *asyncChannel) Sync() error {
+	return c.syncContext(context.Background())
+}
+
+// syncContext 等待调用前写入的日志全部发送,并刷新实现了Syncer的Channel
+func (c *asyncChannel) syncContext(ctx context.Context) error {
+	c.mux.Lock()
+	switch c.getStatus() {
+	case statusNone:
+		c.mux.Unlock()
+		return nil
+	case statusStop:
+		c.mux.Unlock()
+		select {
+		case <-c.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ch := make(chan error, 1)
+	c.waiters = append(c.waiters, ch)
+	c.mux.Unlock()
+	c.notify()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *asyncChannel) closeChannels() {
 	for _, ch := range c.channels {
 		ch.Close()
 	}
 
-	return nil
+	for _, ch := range c.batches {
+		ch.Close()
+	}
+}
+
+// syncChannels 刷新实现了Syncer的Channel,返回第一个错误
+func (c *asyncChannel) syncChannels() error {
+	var res error
+	for _, ch := range c.channels {
+		if s, ok := ch.(Syncer); ok {
+			if err := s.Sync(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+
+	for _, ch := range c.batches {
+		if s, ok := ch.(Syncer); ok {
+			if err := s.Sync(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+
+	return res
+}
+
+// Dropped 返回某个级别累计丢弃的日志数
+func (c *asyncChannel) Dropped(lv Level) uint64 {
+	return atomic.LoadUint64(&c.dropped[lv])
+}
+
+func (c *asyncChannel) getStatus() int32 {
+	return atomic.LoadInt32(&c.status)
+}
+
+// setStatus 修改状态,需要持有锁
+func (c *asyncChannel) setStatus(status int32) {
+	atomic.StoreInt32(&c.status, status)
+}
+
+// notify 唤醒worker
+func (c *asyncChannel) notify() {
+	if c.ring == nil {
+		c.cond.Signal()
+		return
+	}
+
+	select {
+	case c.wakeup <- struct{}{}:
+	default:
+	}
 }
 
 func (c *asyncChannel) Write(e *Entry) {
-	c.mux.Lock()
-	notify := false
-	if c.status == statusRunning && c.queue.Len() < c.logMax {
-		c.queue.Push(e)
-		notify = true
+	if c.ring != nil {
+		c.writeRing(e)
+		return
 	}
+
+	c.mux.Lock()
+	notify := c.push(e)
 	c.mux.Unlock()
 	if notify {
 		c.cond.Signal()
 	}
 }
 
+// push 按照溢出策略写入队列,需要持有锁
+func (c *asyncChannel) push(e *Entry) bool {
+	if c.getStatus() != statusRunning {
+		c.drop(e.Level)
+		return false
+	}
+
+	if c.queue.Len() >= c.logMax && e.Level > c.opts.NoDropLevel {
+		switch c.opts.Overflow {
+		case OverflowDropOldest:
+			old := c.queue.RemoveFirst(c.canDrop)
+			if old == nil {
+				c.drop(e.Level)
+				return false
+			}
+			c.drop(old.Level)
+			old.Free()
+		case OverflowBlock:
+			for c.getStatus() == statusRunning && c.queue.Len() >= c.logMax {
+				c.notFull.Wait()
+			}
+		case OverflowBlockTimeout:
+			c.waitTimeout(c.opts.Timeout)
+		}
+
+		if c.getStatus() != statusRunning || c.queue.Len() >= c.logMax {
+			c.drop(e.Level)
+			return false
+		}
+	}
+
+	c.queue.Push(e)
+	return true
+}
+
+// waitTimeout 等待队列有空间,最多等待timeout,需要持有锁
+func (c *asyncChannel) waitTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	expired := false
+	t := time.AfterFunc(timeout, func() {
+		c.mux.Lock()
+		expired = true
+		c.mux.Unlock()
+		c.notFull.Broadcast()
+	})
+	for !expired && c.getStatus() == statusRunning && c.queue.Len() >= c.logMax {
+		c.notFull.Wait()
+	}
+	t.Stop()
+}
+
+func (c *asyncChannel) canDrop(e *Entry) bool {
+	return e.Level > c.opts.NoDropLevel
+}
+
+func (c *asyncChannel) drop(lv Level) {
+	atomic.AddUint64(&c.dropped[lv], 1)
+}
+
+// writeRing 写入无锁队列,不需要加锁
+// 检查状态之前先标记正在写入,worker退出时会等待写入完成后再次取出
+func (c *asyncChannel) writeRing(e *Entry) {
+	atomic.AddInt32(&c.writers, 1)
+	defer atomic.AddInt32(&c.writers, -1)
+	if c.getStatus() != statusRunning {
+		c.drop(e.Level)
+		return
+	}
+
+	e.Obtain()
+	if !c.ring.Push(e) && !c.pushRingWait(e) {
+		c.drop(e.Level)
+		e.Free()
+		return
+	}
+
+	// worker等待时才需要唤醒
+	if atomic.LoadInt32(&c.sleeping) == 1 && atomic.CompareAndSwapInt32(&c.sleeping, 1, 0) {
+		c.notify()
+	}
+}
+
+// pushRingWait 无锁队列满时,按照溢出策略等待
+func (c *asyncChannel) pushRingWait(e *Entry) bool {
+	var deadline time.Time
+	switch {
+	case e.Level <= c.opts.NoDropLevel, c.opts.Overflow == OverflowBlock:
+	case c.opts.Overflow == OverflowBlockTimeout && c.opts.Timeout > 0:
+		deadline = time.Now().Add(c.opts.Timeout)
+	default:
+		return false
+	}
+
+	for i := 0; c.getStatus() == statusRunning; i++ {
+		if c.ring.Push(e) {
+			return true
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return false
+		}
+		if i < 16 {
+			runtime.Gosched()
+		} else {
+			time.Sleep(ringWaitInterval)
+		}
+	}
+
+	return false
+}
+
+// tickInterval 定时检查的间隔,取汇报间隔和Linger的最小值,0表示不需要定时器
+func (c *asyncChannel) tickInterval() time.Duration {
+	interval := c.opts.ReportInterval
+	for _, b := range c.batchers {
+		if interval == 0 || b.opts.Linger < interval {
+			interval = b.opts.Linger
+		}
+	}
+	if interval > 0 && interval < minBatchTick {
+		interval = minBatchTick
+	}
+	return interval
+}
+
+func (c *asyncChannel) runTicker(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mux.Lock()
+			c.tick = true
+			c.mux.Unlock()
+			c.notify()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// newReport 生成汇报丢弃数量的日志,没有新丢弃时返回nil,需要持有锁
+func (c *asyncChannel) newReport() *Entry {
+	c.lastReport = time.Now()
+	var fields []Field
+	for lv := range c.dropped {
+		dropped := atomic.LoadUint64(&c.dropped[lv])
+		if n := dropped - c.reported[lv]; n > 0 {
+			fields = append(fields, Uint64("dropped_"+strings.ToLower(Level(lv).String()), n))
+		}
+		c.reported[lv] = dropped
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	e := NewEntry(nil)
+	e.Level = WarnLevel
+	e.Text = "glog: async queue overflow, entries dropped"
+	e.Fields = fields
+	return e
+}
+
+// asyncTask worker每次取出的数据
+type asyncTask struct {
+	queue   Queue
+	waiters []chan error
+	tick    bool
+	quit    bool
+	report  *Entry
+	now     time.Time
+}
+
 func (l *asyncChannel) Run() {
+	defer close(l.done)
 	for {
-		l.mux.Lock()
-		for l.status != statusStop && l.queue.Empty() {
-			l.cond.Wait()
+		t := asyncTask{}
+		if l.ring != nil {
+			l.waitRing(&t)
+		} else {
+			l.waitQueue(&t)
 		}
-		quit := l.status == statusStop
-		queue := l.queue
-		l.queue.Clear()
-		l.mux.Unlock()
 
-		if len(l.batches) > 0 {
-			mapper := newBatchMapper()
-			for _, c := range l.batches {
-				batch := mapper.GetBatch(&queue, c)
-				if len(batch) > 0 {
-					c.WriteBatch(batch)
-				}
-			}
+		if t.report != nil {
+			t.queue.Push(t.report)
+			t.report.Free()
 		}
 
-		for {
-			e := queue.Pop()
-			if e == nil {
-				break
-			}
+		l.process(&t.queue)
 
-			for _, c := range l.channels {
-				if c.IsEnable(e.Level) {
-					c.Write(e)
+		// Sync或者退出时全部发送,否则只发送超时的
+		flushAll := t.quit || len(t.waiters) > 0
+		if flushAll || t.tick {
+			for _, b := range l.batchers {
+				if flushAll || b.Expired(t.now) {
+					b.Flush()
 				}
 			}
+		}
 
-			// 使用完释放掉
-			e.Free()
+		// Sync之前写入的日志都已经处理完
+		if len(t.waiters) > 0 {
+			err := l.syncChannels()
+			for _, w := range t.waiters {
+				w <- err
+			}
 		}
 
-		queue.Clear()
-		if quit {
+		if t.quit {
+			l.closeChannels()
 			break
 		}
 	}
 }
 
-type batchMapper struct {
-	batchMap map[Level][]*Entry
+// takeControl 取出定时器,Sync和退出等控制信息,需要持有锁
+func (l *asyncChannel) takeControl(t *asyncTask) {
+	t.quit = l.getStatus() == statusStop
+	t.tick = l.tick
+	l.tick = false
+	t.now = time.Now()
+	if t.quit || (l.opts.ReportInterval > 0 && t.now.Sub(l.lastReport) >= l.opts.ReportInterval) {
+		t.report = l.newReport()
+	}
+	t.waiters = l.waiters
+	l.waiters = nil
 }
 
-func newBatchMapper() batchMapper {
-	return batchMapper{batchMap: make(map[Level][]*Entry)}
+// waitQueue 等待链表队列中有数据,并全部取出
+func (l *asyncChannel) waitQueue(t *asyncTask) {
+	l.mux.Lock()
+	for l.getStatus() != statusStop && l.queue.Empty() && !l.tick && len(l.waiters) == 0 {
+		l.cond.Wait()
+	}
+	l.takeControl(t)
+	// 取出全部数据,不能调用Clear,否则会释放掉Entry
+	t.queue = l.queue
+	l.queue = Queue{}
+	l.notFull.Broadcast()
+	l.mux.Unlock()
 }
 
-func (b *batchMapper) GetBatch(q *Queue, c BatchChannel) []*Entry {
-	if res, ok := b.batchMap[c.Level()]; ok {
-		return res
+// waitRing 等待无锁队列中有数据,最多取出队列容量的数据
+func (l *asyncChannel) waitRing(t *asyncTask) {
+	for {
+		l.mux.Lock()
+		l.takeControl(t)
+		l.mux.Unlock()
+
+		// 在取出控制信息之前写入的日志,一定已经在队列中
+		for i := 0; i < l.ring.Cap(); i++ {
+			e := l.ring.Pop()
+			if e == nil {
+				break
+			}
+			t.queue.append(e)
+		}
+
+		if t.quit {
+			// 检查状态之后才写入的生产者,等待写入完成后全部取出
+			for atomic.LoadInt32(&l.writers) != 0 {
+				runtime.Gosched()
+			}
+			for e := l.ring.Pop(); e != nil; e = l.ring.Pop() {
+				t.queue.append(e)
+			}
+			return
+		}
+
+		if !t.queue.Empty() || t.tick || t.report != nil || len(t.waiters) > 0 {
+			return
+		}
+
+		// 标记等待后需要再次检查,避免丢失唤醒
+		atomic.StoreInt32(&l.sleeping, 1)
+		if l.ring.Empty() {
+			<-l.wakeup
+		}
+		atomic.StoreInt32(&l.sleeping, 0)
 	}
+}
+
+// process 将队列中的日志写入所有Channel,BatchChannel先累积
+func (l *asyncChannel) process(queue *Queue) {
+	for {
+		e := queue.Pop()
+		if e == nil {
+			break
+		}
 
-	res := make([]*Entry, 0, q.Len())
-	iter := q.Iterator()
-	for iter.HasNext() {
-		e := iter.Next()
-		if c.IsEnable(e.Level) {
-			res = append(res, e)
+		for _, b := range l.batchers {
+			b.Add(e)
 		}
+
+		for _, c := range l.channels {
+			if c.IsEnable(e.Level) {
+				writeChannel(c, e)
+			}
+		}
+
+		// 使用完释放掉
+		e.Free()
 	}
 
-	b.batchMap[c.Level()] = res
-	return res
+	queue.Clear()
 }
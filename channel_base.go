@@ -1,18 +1,31 @@
 package glog
 
+import "fmt"
+
 // BaseChannel 默认实现
 type BaseChannel struct {
+	name      string // 报告错误时使用,构造时设置为Name(),尚未加入Logger时也有效
+	metrics   channelMetrics
+	onError   ErrorHandler
 	level     Level
 	formatter Formatter
+	maxLevel  Level
+	filters   []Filter
+	async     AsyncOptions
+	batch     batchOptions
 }
 
 func (c *BaseChannel) Init(o *ChannelOptions) {
 	c.level = o.Level
+	c.maxLevel = o.MaxLevel
+	c.filters = o.Filters
 	c.formatter = o.Formatter
+	c.async = o.Async
+	c.batch = batchOptions{Size: o.Batch, Bytes: o.BatchBytes, Linger: o.Linger}
 }
 
 func (c *BaseChannel) IsEnable(lv Level) bool {
-	return c.level <= lv
+	return lv <= c.level && lv >= c.maxLevel
 }
 
 func (c *BaseChannel) Level() Level {
@@ -31,16 +44,108 @@ func (c *BaseChannel) Close() error {
 	return nil
 }
 
+// Format 格式化Entry,相同Formatter的结果缓存在Entry中,不能在Entry释放后使用
 func (c *BaseChannel) Format(e *Entry) []byte {
-	e.Lock()
-	defer e.Unlock()
-	if data, ok := e.outputs[c.formatter]; ok {
-		return data
-	}
-	data, err := c.formatter.Format(e)
+	data, err := e.format(c.formatter)
 	if err != nil {
+		c.metrics.formatErrors.Inc()
+		c.handleError(fmt.Errorf("format %s: %w", c.formatter.Name(), err), e)
 		return nil
 	}
-	e.outputs[c.formatter] = data
 	return data
 }
+
+// HandleError 报告写入失败,批量发送时e为nil
+func (c *BaseChannel) HandleError(err error, e *Entry) {
+	c.metrics.writeErrors.Inc()
+	c.handleError(err, e)
+}
+
+// handleError 调用ErrorHandler,未加入Logger时使用DefaultErrorHandler
+func (c *BaseChannel) handleError(err error, e *Entry) {
+	h := c.onError
+	if h == nil {
+		h = DefaultErrorHandler
+	}
+	h(c.name, err, e)
+}
+
+// channelFilters 返回Channel单独配置的Filters
+func (c *BaseChannel) channelFilters() []Filter {
+	return c.filters
+}
+
+// filterEntry 执行Channel单独配置的Filters,修改的是副本,不影响其他Channel
+// 返回的Entry与e不同时需要Free,返回false表示忽略该条日志
+func filterEntry(c Channel, e *Entry) (*Entry, bool) {
+	f, ok := c.(channelFilterer)
+	if !ok {
+		return e, true
+	}
+	filters := f.channelFilters()
+	if len(filters) == 0 {
+		return e, true
+	}
+
+	ce := e.clone()
+	for _, filter := range filters {
+		if err := filter(ce); err != nil {
+			ce.Free()
+			return nil, false
+		}
+	}
+	return ce, true
+}
+
+// writeChannel 写入Channel,配置了Filters时写入过滤后的副本
+func writeChannel(c Channel, e *Entry) {
+	ce, ok := filterEntry(c, e)
+	if !ok {
+		return
+	}
+	c.Write(ce)
+	if ce != e {
+		ce.Free()
+	}
+}
+
+// tryWrite 写入Channel,未实现TryWriter时调用Write,认为写入成功
+func tryWrite(c Channel, e *Entry) error {
+	ce, ok := filterEntry(c, e)
+	if !ok {
+		return nil
+	}
+	if ce != e {
+		defer ce.Free()
+	}
+	if w, ok := c.(TryWriter); ok {
+		return w.TryWrite(ce)
+	}
+	c.Write(ce)
+	return nil
+}
+
+// asyncOptions 返回独立异步队列的配置,未配置时返回false
+func (c *BaseChannel) asyncOptions() (AsyncOptions, bool) {
+	return c.async, c.async.LogMax > 0
+}
+
+// batchOptions 返回批量发送配置,只对BatchChannel有效
+func (c *BaseChannel) batchOptions() batchOptions {
+	return c.batch
+}
+
+// channelMetrics Channel相关统计,未加入Logger时为nil,不统计
+type channelMetrics struct {
+	formatErrors *Counter
+	writeErrors  *Counter
+}
+
+// setup 加入Logger时调用,注入Logger的配置
+func (c *BaseChannel) setup(name string, conf *Config) {
+	c.name = name
+	c.onError = conf.ErrorHandler
+	m := conf.Metrics
+	c.metrics.formatErrors = m.Counter("glog_format_errors_total", "Number of entries the formatter failed to format.", "channel", name)
+	c.metrics.writeErrors = m.Counter("glog_write_errors_total", "Number of failed channel writes.", "channel", name)
+}
@@ -0,0 +1,110 @@
+package glog
+
+import "time"
+
+const (
+	DefaultBatchSize   = 100                   // 默认一批最多发送的日志条数
+	DefaultBatchBytes  = 5 * 1024 * 1024       // 默认一批最多发送的字节数
+	DefaultBatchLinger = time.Second           // 默认未满一批时的最长等待时间
+	minBatchTick       = time.Millisecond * 10 // 检查Linger的最小间隔
+)
+
+// batchOptions 批量发送配置,由ChannelOptions的Batch,BatchBytes,Linger设置
+type batchOptions struct {
+	Size   int
+	Bytes  int
+	Linger time.Duration
+}
+
+// batchOptioner 由BaseChannel实现
+type batchOptioner interface {
+	batchOptions() batchOptions
+}
+
+// formatChannel 由BaseChannel实现,用于计算日志大小
+type formatChannel interface {
+	Format(e *Entry) []byte
+}
+
+// batcher 为BatchChannel累积日志,达到条数,字节数或者等待时间后调用WriteBatch
+// 非线程安全,只在异步队列的worker中使用
+type batcher struct {
+	channel BatchChannel
+	opts    batchOptions
+	entries []*Entry
+	bytes   int
+	first   time.Time // 第一条日志加入的时间
+}
+
+func newBatcher(c BatchChannel) *batcher {
+	b := &batcher{channel: c}
+	if bo, ok := c.(batchOptioner); ok {
+		b.opts = bo.batchOptions()
+	}
+	if b.opts.Size <= 0 {
+		b.opts.Size = DefaultBatchSize
+	}
+	if b.opts.Bytes <= 0 {
+		b.opts.Bytes = DefaultBatchBytes
+	}
+	if b.opts.Linger <= 0 {
+		b.opts.Linger = DefaultBatchLinger
+	}
+	b.entries = make([]*Entry, 0, b.opts.Size)
+	return b
+}
+
+// Add 添加日志,满足条件时立即发送
+func (b *batcher) Add(e *Entry) {
+	if !b.channel.IsEnable(e.Level) {
+		return
+	}
+	ce, ok := filterEntry(b.channel, e)
+	if !ok {
+		return
+	}
+	if ce == e {
+		e.Obtain()
+	}
+	e = ce
+
+	size := 0
+	if fc, ok := b.channel.(formatChannel); ok {
+		size = len(fc.Format(e))
+	}
+
+	// 超过字节数限制,先发送已有的
+	if len(b.entries) > 0 && b.bytes+size > b.opts.Bytes {
+		b.Flush()
+	}
+
+	if len(b.entries) == 0 {
+		b.first = time.Now()
+	}
+	b.entries = append(b.entries, e)
+	b.bytes += size
+
+	if len(b.entries) >= b.opts.Size || b.bytes >= b.opts.Bytes {
+		b.Flush()
+	}
+}
+
+// Expired 是否超过Linger时间
+func (b *batcher) Expired(now time.Time) bool {
+	return len(b.entries) > 0 && now.Sub(b.first) >= b.opts.Linger
+}
+
+// Flush 发送并释放所有日志
+func (b *batcher) Flush() {
+	if len(b.entries) == 0 {
+		return
+	}
+
+	b.channel.WriteBatch(b.entries)
+	for i, e := range b.entries {
+		e.Free()
+		b.entries[i] = nil
+	}
+	b.entries = b.entries[:0]
+	b.bytes = 0
+}
@@ -0,0 +1,291 @@
+package glog
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断打开时写入直接返回该错误
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrWriteTimeout 写入超时
+var ErrWriteTimeout = errors.New("write timeout")
+
+const (
+	// DefaultBreakerFailures 默认连续失败多少次后熔断
+	DefaultBreakerFailures = 5
+	// DefaultBreakerOpenDuration 默认熔断持续时间
+	DefaultBreakerOpenDuration = time.Second * 10
+)
+
+const (
+	// BreakerClosed 正常写入
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 熔断,直接丢弃或者写入Fallback
+	BreakerOpen
+	// BreakerHalfOpen 熔断结束后尝试写入,成功则恢复,失败则重新熔断
+	BreakerHalfOpen
+)
+
+// BreakerState 熔断状态
+type BreakerState int32
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerOptions 熔断配置
+type BreakerOptions struct {
+	Failures     int           // 连续失败或超时多少次后熔断,默认5
+	Timeout      time.Duration // 单次写入超时,超时算作失败,0表示不检查
+	OpenDuration time.Duration // 熔断持续时间,之后进入半开状态,默认10s
+	Probes       int           // 半开状态下连续成功多少次后恢复,默认1
+	Fallback     Channel       // 熔断时写入的Channel,nil则丢弃
+}
+
+// NewCircuitBreakerChannel 创建熔断Channel,inner连续失败或超时后不再写入,避免阻塞整个Logger
+// inner需要实现TryWriter才能知道写入失败,否则只能检测超时
+// 状态变化通过ErrorHandler报告
+func NewCircuitBreakerChannel(inner Channel, o BreakerOptions) Channel {
+	if o.Failures <= 0 {
+		o.Failures = DefaultBreakerFailures
+	}
+	if o.OpenDuration <= 0 {
+		o.OpenDuration = DefaultBreakerOpenDuration
+	}
+	if o.Probes <= 0 {
+		o.Probes = 1
+	}
+	c := &breakerChannel{inner: inner, opts: o}
+	c.Init(NewChannelOptions())
+	c.name = c.Name()
+	return c
+}
+
+// breakerChannel 熔断器,半开状态下同时只有一个试探写入
+type breakerChannel struct {
+	BaseChannel
+	inner     Channel
+	opts      BreakerOptions
+	mux       sync.Mutex
+	state     BreakerState
+	failures  int       // 连续失败次数
+	successes int       // 半开状态下连续成功次数
+	probing   bool      // 半开状态下是否有试探写入
+	openedAt  time.Time // 熔断开始时间
+	rejected  *Counter
+}
+
+func (c *breakerChannel) Name() string {
+	return "breaker_" + c.inner.Name()
+}
+
+// setup 同时注入inner和Fallback的配置
+func (c *breakerChannel) setup(name string, conf *Config) {
+	c.BaseChannel.setup(name, conf)
+	for _, ch := range c.targets() {
+		if cs, ok := ch.(channelSetup); ok {
+			cs.setup(ch.Name(), conf)
+		}
+	}
+	m := conf.Metrics
+	c.rejected = m.Counter("glog_breaker_rejected_total", "Number of entries not written to the channel because the circuit breaker was open.", "channel", name)
+	m.GaugeFunc("glog_breaker_state", "Circuit breaker state, 0 closed, 1 open, 2 half-open.", func() float64 {
+		return float64(c.State())
+	}, "channel", name)
+}
+
+func (c *breakerChannel) targets() []Channel {
+	if c.opts.Fallback != nil {
+		return []Channel{c.inner, c.opts.Fallback}
+	}
+	return []Channel{c.inner}
+}
+
+func (c *breakerChannel) IsEnable(lv Level) bool {
+	return c.inner.IsEnable(lv)
+}
+
+// State 返回当前状态
+func (c *breakerChannel) State() BreakerState {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.state
+}
+
+func (c *breakerChannel) Open() error {
+	var res error
+	for _, ch := range c.targets() {
+		if err := ch.Open(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (c *breakerChannel) Close() error {
+	var res error
+	for _, ch := range c.targets() {
+		if err := ch.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (c *breakerChannel) Sync() error {
+	var res error
+	for _, ch := range c.targets() {
+		if s, ok := ch.(Syncer); ok {
+			if err := s.Sync(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+func (c *breakerChannel) Reopen() error {
+	var res error
+	for _, ch := range c.targets() {
+		if r, ok := ch.(Reopener); ok {
+			if err := r.Reopen(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+func (c *breakerChannel) Write(e *Entry) {
+	if err := c.TryWrite(e); err != nil && err != ErrCircuitOpen {
+		c.HandleError(err, e)
+	}
+}
+
+// TryWrite 熔断时写入Fallback,没有Fallback则返回ErrCircuitOpen
+func (c *breakerChannel) TryWrite(e *Entry) error {
+	allowed, probe := c.allow()
+	if !allowed {
+		c.rejected.Inc()
+		if fb := c.opts.Fallback; fb != nil {
+			if !fb.IsEnable(e.Level) {
+				return nil
+			}
+			return tryWrite(fb, e)
+		}
+		return ErrCircuitOpen
+	}
+
+	err := c.write(e)
+	c.done(probe, err)
+	return err
+}
+
+// write 写入inner,超时后不再等待,写入的goroutine结束后释放Entry
+func (c *breakerChannel) write(e *Entry) error {
+	if c.opts.Timeout <= 0 {
+		return tryWrite(c.inner, e)
+	}
+
+	result := make(chan error, 1)
+	e.Obtain()
+	go func() {
+		result <- tryWrite(c.inner, e)
+		e.Free()
+	}()
+
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return ErrWriteTimeout
+	}
+}
+
+// allow 判断是否可以写入,probe表示是否为半开状态下的试探写入
+func (c *breakerChannel) allow() (allowed bool, probe bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch c.state {
+	case BreakerOpen:
+		if time.Since(c.openedAt) < c.opts.OpenDuration {
+			return false, false
+		}
+		c.setState(BreakerHalfOpen, nil)
+		c.probing = true
+		return true, true
+	case BreakerHalfOpen:
+		if c.probing {
+			return false, false
+		}
+		c.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// done 记录写入结果
+func (c *breakerChannel) done(probe bool, err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if probe {
+		c.probing = false
+		if err != nil {
+			c.setState(BreakerOpen, err)
+			return
+		}
+		c.successes++
+		if c.successes >= c.opts.Probes {
+			c.setState(BreakerClosed, nil)
+		}
+		return
+	}
+
+	// 熔断前开始的写入,结果不再统计
+	if c.state != BreakerClosed {
+		return
+	}
+	if err == nil {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= c.opts.Failures {
+		c.setState(BreakerOpen, err)
+	}
+}
+
+// setState 切换状态并通过ErrorHandler报告,err为导致熔断的错误
+func (c *breakerChannel) setState(state BreakerState, err error) {
+	from := c.state
+	c.state = state
+	c.failures = 0
+	c.successes = 0
+	if state == BreakerOpen {
+		c.openedAt = time.Now()
+	}
+	if from == state {
+		return
+	}
+
+	if err != nil {
+		c.handleError(fmt.Errorf("circuit breaker %s -> %s: %w", from, state, err), nil)
+	} else {
+		c.handleError(fmt.Errorf("circuit breaker %s -> %s", from, state), nil)
+	}
+}
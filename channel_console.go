@@ -1,9 +1,8 @@
 package glog
 
 import (
-	"fmt"
+	"bytes"
 	"os"
-	"runtime"
 )
 
 // Foreground colors.
@@ -21,51 +20,89 @@ const (
 // Color represents a text color.
 type Color uint8
 
-// NewConsoleChannel 创建控制台输出Channel
+// NewConsoleChannel 创建控制台输出Channel,默认输出到stdout
+// WithStderr输出到stderr,WithSplit按照级别分别输出,是否着色根据各自的文件描述符和环境变量判断,见ColorEnabled
+// Layout中没有颜色时按照配色给整行着色,可通过WithColorScheme修改
 func NewConsoleChannel(opts ...ChannelOption) Channel {
 	o := NewChannelOptions(opts...)
-	c := &consoleChannel{writer: os.Stdout}
-	c.color = runtime.GOOS != "windows" && IsTerminal(int(c.writer.Fd()))
+	c := &consoleChannel{scheme: DefaultColorScheme}
+	if o.ColorScheme != nil {
+		c.scheme = *o.ColorScheme
+	}
+	switch {
+	case o.Split:
+		c.stdout = newLineWriter(os.Stdout, o)
+		c.stderr = newLineWriter(os.Stderr, o)
+		c.splitLevel = o.SplitLevel
+	case o.Stderr:
+		c.stdout = newLineWriter(os.Stderr, o)
+	default:
+		c.stdout = newLineWriter(os.Stdout, o)
+	}
 	c.Init(o)
+	c.name = c.Name()
 	return c
 }
 
-var levelToColor = map[Level]Color{
-	TraceLevel: Blue,
-	DebugLevel: Blue,
-	InfoLevel:  Cyan,
-	WarnLevel:  Yellow,
-	ErrorLevel: Red,
-	FatalLevel: Magenta,
-}
-
 // consoleChannel 控制台输出
 type consoleChannel struct {
 	BaseChannel
-	writer *os.File
-	color  bool
+	stdout     *lineWriter
+	stderr     *lineWriter // 非nil时不低于splitLevel的日志输出到stderr
+	splitLevel Level
+	scheme     ColorScheme
 }
 
 func (c *consoleChannel) Name() string {
 	return "console"
 }
 
+func (c *consoleChannel) Close() error {
+	return c.Sync()
+}
+
+func (c *consoleChannel) Sync() error {
+	err := c.stdout.Flush()
+	if c.stderr != nil {
+		if e := c.stderr.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// output 根据级别选择输出
+func (c *consoleChannel) output(lv Level) *lineWriter {
+	if c.stderr != nil && lv <= c.splitLevel {
+		return c.stderr
+	}
+	return c.stdout
+}
+
 func (c *consoleChannel) Write(e *Entry) {
 	if text := c.Format(e); len(text) != 0 {
-		// auto change line
-		if text[len(text)-1] != '\n' {
-			text = append(text, '\n')
+		out := c.output(e.Level)
+		if out.color && bytes.IndexByte(text, 0x1b) == -1 {
+			b := NewBuffer()
+			defer b.Free()
+			text = c.AddColor(b, e.Level, text)
 		}
 
-		if c.color {
-			text = c.AddColor(e.Level, text)
+		if err := out.WriteLine(text); err != nil {
+			c.HandleError(err, e)
 		}
-
-		c.writer.Write(text)
 	}
 }
 
-func (c *consoleChannel) AddColor(lv Level, text []byte) []byte {
-	result := fmt.Sprintf("\x1b[%dm%s\x1b[0m", uint8(levelToColor[lv]), text)
-	return []byte(result)
+// AddColor 按照配色给整行着色,结果写入b,没有配色时直接返回text
+func (c *consoleChannel) AddColor(b *Buffer, lv Level, text []byte) []byte {
+	style := c.scheme[lv]
+	if style.IsEmpty() {
+		return text
+	}
+	style.AppendStart(b)
+	b.AppendBytes(bytes.TrimRight(text, "\n"))
+	style.AppendEnd(b)
+	b.AppendByte('\n')
+	return b.Bytes()
 }
@@ -3,8 +3,10 @@ package glog
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"path"
+	"strings"
 	"time"
 )
 
@@ -13,9 +15,14 @@ func NewElasticChannel(opts ...ChannelOption) Channel {
 	o := NewChannelOptions(opts...)
 	c := &elasticChannel{}
 	c.Init(o)
+	c.name = c.Name()
+	// client创建后不再修改,可以并发使用
 	c.client = o.HttpClient
+	if c.client == nil {
+		c.client = &http.Client{Timeout: time.Second * 10}
+	}
+	c.URL = strings.TrimRight(o.URL, "/")
 	c.Retry = o.Retry
-	c.Bulk = o.Batch
 	c.Index = o.IndexName
 	return c
 }
@@ -24,13 +31,12 @@ func NewElasticChannel(opts ...ChannelOption) Channel {
 // 但是比较厚重,这里只需要Index
 // https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-index_.html
 // https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
-// TODO:支持Bulk模式,需要ndjson编码
+// 异步模式下通过WriteBatch以Bulk方式发送,批次大小由Batch,BatchBytes,Linger控制
 // formatter编码格式必须是json格式
 type elasticChannel struct {
 	BaseChannel
 	URL    string       // 地址
-	Retry  int          // 失败重试次数,默认不重试,直接丢弃
-	Bulk   int          // 用于配置一个批次发送多少条日志,默认1条
+	Retry  RetryOptions // 失败重试策略,默认不重试,直接丢弃
 	Index  string       // 索引名,按照日期分类?
 	client *http.Client //
 }
@@ -40,38 +46,62 @@ func (c *elasticChannel) Name() string {
 }
 
 func (c *elasticChannel) Open() error {
-	if c.client == nil {
-		c.client = &http.Client{Timeout: time.Second * 10}
-	}
-
 	return nil
 }
 
 func (c *elasticChannel) Close() error {
-	if c.client != nil {
-		c.client.CloseIdleConnections()
-		c.client = nil
-	}
-
+	c.client.CloseIdleConnections()
 	return nil
 }
 
 // 处理速度可能比较慢,放到单独一个队列中处理
 func (c *elasticChannel) Write(msg *Entry) {
-	if c.Open() != nil {
-		return
+	if err := c.TryWrite(msg); err != nil {
+		c.HandleError(err, msg)
 	}
+}
 
+func (c *elasticChannel) TryWrite(msg *Entry) error {
 	// POST /<index>/_doc/
 	// POST /<index>/_create/<_id>
 	text := c.Format(msg)
-	url := path.Join(c.URL, c.getIndex(), "_doc")
-	_ = c.doPost(url, "application/json", text)
+	if text == nil {
+		return nil
+	}
+	url := c.URL + "/" + c.getIndex() + "/_doc"
+	return c.doPost(url, "application/json", text)
 }
 
-// WriteBatch 批量发送
-func (c *elasticChannel) WriteBatch(q *Queue) {
+// WriteBatch 以Bulk方式批量发送
+// POST /<index>/_bulk
+func (c *elasticChannel) WriteBatch(msgs []*Entry) {
+	buf := NewBuffer()
+	defer buf.Free()
+	for _, e := range msgs {
+		text := c.Format(e)
+		if text == nil {
+			continue
+		}
+		// ndjson格式,每条数据前需要action,index由url指定
+		buf.AppendString(`{"index":{}}`)
+		buf.AppendByte('\n')
+		buf.AppendBytes(bytes.TrimRight(text, "\n"))
+		buf.AppendByte('\n')
+	}
 
+	if buf.Empty() {
+		return
+	}
+
+	url := c.URL + "/" + c.getIndex() + "/_bulk"
+	if err := c.doPost(url, "application/x-ndjson", buf.Bytes()); err != nil {
+		c.HandleError(err, nil)
+	}
+}
+
+// HealthCheck 请求根路径检查集群是否可用
+func (c *elasticChannel) HealthCheck() error {
+	return httpCheck(c.client, c.URL+"/")
 }
 
 // 根据当前时间按天进行索引
@@ -81,23 +111,29 @@ func (c *elasticChannel) getIndex() string {
 	return index
 }
 
-// POST /_bulk
-// POST /<index>/_bulk
-// TODO:support ndjson
-// func (c *elasticChannel) doSendBulk(msg []*Entry) {
-// 	//url := path.Join(c.URL, c.getIndex(), "_bulk")
-// 	//c.doPost(url, "application/x-ndjson")
-// }
-
 // 添加重试功能,失败则丢弃
 func (c *elasticChannel) doPost(url, contentType string, data []byte) error {
-	var err error
-	for i := 0; i < c.Retry+1; i++ {
-		_, err = c.client.Post(url, contentType, bytes.NewReader(data))
-		if err == nil {
-			return nil
-		}
+	return c.Retry.Do(func() error {
+		return c.post(url, contentType, data)
+	})
+}
+
+// post 发送请求,非2xx返回或者bulk部分失败都作为错误,bulk部分失败时不重试,避免重复写入
+func (c *elasticChannel) post(url, contentType string, data []byte) error {
+	rsp, err := c.client.Post(url, contentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	// errors字段位于bulk返回的开头,只需读取少量数据
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 512))
+	_, _ = io.Copy(ioutil.Discard, rsp.Body)
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return newHTTPError(rsp, body)
+	}
+	if bytes.Contains(body, []byte(`"errors":true`)) {
+		return fmt.Errorf("elastic: bulk request has errors: %s", body)
 	}
 
-	return err
+	return nil
 }
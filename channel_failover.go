@@ -0,0 +1,206 @@
+package glog
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultFailoverCheckInterval 默认检查主Channel是否恢复的间隔
+const DefaultFailoverCheckInterval = time.Second * 10
+
+// HealthChecker 可选接口,检查Channel是否可用,FailoverChannel用于判断主Channel是否恢复
+type HealthChecker interface {
+	HealthCheck() error
+}
+
+// FailoverOptions 故障转移配置
+type FailoverOptions struct {
+	CheckInterval time.Duration // 切换到备用Channel后,检查主Channel是否恢复的间隔,默认10s
+}
+
+// NewFailoverChannel 创建故障转移Channel,优先写入primary,失败时按顺序切换到secondaries
+// 比如graylog为主,本地文件为备用
+func NewFailoverChannel(primary Channel, secondaries ...Channel) Channel {
+	return NewFailoverChannelWithOptions(FailoverOptions{}, primary, secondaries...)
+}
+
+// NewFailoverChannelWithOptions 通过配置创建故障转移Channel
+// 切换后定期检查主Channel,实现了HealthChecker则在后台调用HealthCheck,否则尝试写入一条日志,成功后切换回来
+func NewFailoverChannelWithOptions(o FailoverOptions, primary Channel, secondaries ...Channel) Channel {
+	if o.CheckInterval <= 0 {
+		o.CheckInterval = DefaultFailoverCheckInterval
+	}
+	c := &failoverChannel{opts: o}
+	c.Init(NewChannelOptions())
+	c.targets = append([]Channel{primary}, secondaries...)
+	c.name = c.Name()
+	return c
+}
+
+// failoverChannel 同一时刻只写入一个Channel,写入失败时依次尝试后面的Channel
+type failoverChannel struct {
+	BaseChannel
+	opts      FailoverOptions
+	targets   []Channel
+	active    int32 // 当前写入的Channel
+	lastCheck int64 // 上次检查主Channel的时间
+	checking  int32 // 是否正在后台检查
+	switches  *Counter
+}
+
+func (c *failoverChannel) Name() string {
+	return "failover_" + c.targets[0].Name()
+}
+
+// Active 返回当前写入的Channel名
+func (c *failoverChannel) Active() string {
+	return c.targets[c.getActive()].Name()
+}
+
+func (c *failoverChannel) getActive() int {
+	return int(atomic.LoadInt32(&c.active))
+}
+
+// setup 同时注入所有目标的配置
+func (c *failoverChannel) setup(name string, conf *Config) {
+	c.BaseChannel.setup(name, conf)
+	m := conf.Metrics
+	c.switches = m.Counter("glog_failover_switches_total", "Number of times the failover channel switched its active target.", "channel", name)
+	for i, ch := range c.targets {
+		if cs, ok := ch.(channelSetup); ok {
+			cs.setup(ch.Name(), conf)
+		}
+		i := i
+		m.GaugeFunc("glog_failover_active", "Whether the target is the active one of the failover channel.", func() float64 {
+			if c.getActive() == i {
+				return 1
+			}
+			return 0
+		}, "channel", name, "target", ch.Name())
+	}
+}
+
+func (c *failoverChannel) IsEnable(lv Level) bool {
+	return c.targets[c.getActive()].IsEnable(lv)
+}
+
+func (c *failoverChannel) Open() error {
+	var res error
+	for _, ch := range c.targets {
+		if err := ch.Open(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (c *failoverChannel) Close() error {
+	var res error
+	for _, ch := range c.targets {
+		if err := ch.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (c *failoverChannel) Sync() error {
+	var res error
+	for _, ch := range c.targets {
+		if s, ok := ch.(Syncer); ok {
+			if err := s.Sync(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+func (c *failoverChannel) Reopen() error {
+	var res error
+	for _, ch := range c.targets {
+		if r, ok := ch.(Reopener); ok {
+			if err := r.Reopen(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+func (c *failoverChannel) Write(e *Entry) {
+	if err := c.TryWrite(e); err != nil {
+		c.HandleError(err, e)
+	}
+}
+
+// TryWrite 写入当前Channel,失败时依次尝试后面的Channel,全部失败时返回最后一个错误
+func (c *failoverChannel) TryWrite(e *Entry) error {
+	active := c.getActive()
+	if active > 0 && c.checkPrimary() {
+		// 主Channel不支持HealthCheck时,尝试写入
+		if err := tryWrite(c.targets[0], e); err == nil {
+			c.switchTo(active, 0, nil)
+			return nil
+		}
+	}
+
+	var err, cause error
+	for i := active; i < len(c.targets); i++ {
+		ch := c.targets[i]
+		if !ch.IsEnable(e.Level) {
+			continue
+		}
+		if err = tryWrite(ch, e); err == nil {
+			if i != active {
+				c.switchTo(active, i, cause)
+			}
+			return nil
+		}
+		if i == active {
+			cause = err
+		}
+	}
+
+	return err
+}
+
+// checkPrimary 到达检查间隔时检查主Channel,需要尝试写入时返回true
+func (c *failoverChannel) checkPrimary() bool {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&c.lastCheck)
+	if now-last < int64(c.opts.CheckInterval) || !atomic.CompareAndSwapInt64(&c.lastCheck, last, now) {
+		return false
+	}
+
+	hc, ok := c.targets[0].(HealthChecker)
+	if !ok {
+		return true
+	}
+	if atomic.CompareAndSwapInt32(&c.checking, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&c.checking, 0)
+			if err := hc.HealthCheck(); err == nil {
+				c.switchTo(c.getActive(), 0, nil)
+			}
+		}()
+	}
+	return false
+}
+
+// switchTo 切换当前Channel并报告,err为导致切换的错误
+func (c *failoverChannel) switchTo(from, to int, err error) {
+	if from == to || !atomic.CompareAndSwapInt32(&c.active, int32(from), int32(to)) {
+		return
+	}
+	if to > 0 {
+		atomic.StoreInt64(&c.lastCheck, time.Now().UnixNano())
+	}
+	c.switches.Inc()
+	if err != nil {
+		c.handleError(fmt.Errorf("failover: switch from %s to %s: %w", c.targets[from].Name(), c.targets[to].Name(), err), nil)
+	} else {
+		c.handleError(fmt.Errorf("failover: switch from %s to %s", c.targets[from].Name(), c.targets[to].Name()), nil)
+	}
+}
@@ -1,77 +1,317 @@
 package glog
 
 import (
+	"bytes"
 	"fmt"
 	"os"
-	"path"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 var (
 	ErrNotReady = fmt.Errorf("channel not ready")
+	ErrClosed   = fmt.Errorf("channel closed")
 )
 
-// NewFileChannel ...
+// DefaultFlushInterval 有缓存时默认写入文件的间隔
+const DefaultFlushInterval = time.Second
+
+const (
+	// FsyncNever 不主动同步,由操作系统决定,默认
+	FsyncNever FsyncMode = iota
+	// FsyncInterval 定期同步
+	FsyncInterval
+	// FsyncAlways 每条日志写入后同步
+	FsyncAlways
+	// FsyncOnLevel 不低于指定级别的日志写入后同步
+	FsyncOnLevel
+)
+
+// FsyncMode 同步到磁盘的策略
+type FsyncMode int
+
+// NewFileChannel 创建文件输出Channel,通过WithFile设置路径,默认为程序名.log
+// 通过WithMaxSize,WithMaxBackups,WithBackupNaming按大小切割
+// 通过WithCompressType,WithMaxAge,WithMaxTotalSize在后台压缩和清理切割后的文件,启动时也会处理,WithRotateHook设置切割后的回调
+// 路径中含有时间时按时间切割,比如logs/app-%d{yyyyMMdd-HH}.log每小时一个文件,可通过WithTimeZone设置时区,WithSymlink设置指向当前文件的软链接
+// 通过WithBufferSize,WithFlushInterval使用缓存,WithFsyncInterval,WithFsyncAlways,WithFsyncLevel设置同步到磁盘的策略
+// 通过WithWatchInterval检测外部工具的切割,也可以在切割后调用Reopen
+// 路径中可以使用%x{key}按Tag或Field拆分文件,%p按级别拆分,比如logs/%x{tenant}.log,WithLevelFile额外输出不低于某个级别的日志,
+// 拆分后的文件使用相同的切割和清理配置,最多同时打开WithMaxOpenFiles个文件
+// 多个进程写入同一个文件时使用WithShared,切割时通过文件锁协调
+// Layout中的颜色不会写入文件
 func NewFileChannel(opts ...ChannelOption) Channel {
 	o := NewChannelOptions(opts...)
-	c := &fileChannel{}
+	path := o.File
+	if path == "" {
+		path = fmt.Sprintf("%s.log", filepath.Base(os.Args[0]))
+	}
+	c := &fileChannel{fsync: o.Fsync, fsyncLevel: o.FsyncLevel, fsyncInterval: o.FsyncInterval, watchInterval: o.WatchInterval}
+	if o.BufferSize > 0 {
+		c.flushInterval = o.FlushInterval
+		if c.flushInterval <= 0 {
+			c.flushInterval = DefaultFlushInterval
+		}
+	}
+	if c.fsync == FsyncInterval && c.fsyncInterval <= 0 {
+		c.fsyncInterval = time.Second
+	}
 	c.Init(o)
+	c.name = c.Name()
+	c.files = newFileSet(o, func(err error) {
+		c.handleError(err, nil)
+	})
+
+	var err error
+	if c.main, err = parsePathTemplate(path); err != nil {
+		DefaultErrorHandler("", fmt.Errorf("invalid file %q: %w", path, err), nil)
+		c.main = &pathTemplate{raw: path}
+	}
+	for _, lf := range o.LevelFiles {
+		t, err := parsePathTemplate(lf.Path)
+		if err != nil {
+			DefaultErrorHandler("", fmt.Errorf("invalid file %q: %w", lf.Path, err), nil)
+			continue
+		}
+		c.levels = append(c.levels, levelTemplate{level: lf.Level, path: t})
+	}
+	// 不依赖Entry的文件提前检查路径
+	for _, t := range c.static() {
+		c.files.pin(t.raw, t == c.main)
+	}
 	return c
 }
 
+// levelTemplate 不低于level的日志写入path
+type levelTemplate struct {
+	level Level
+	path  *pathTemplate
+}
+
 // fileChannel 输出到文件
 type fileChannel struct {
 	BaseChannel
-	file *os.File
-	path string
-	err  error
+	mux           sync.Mutex
+	main          *pathTemplate
+	levels        []levelTemplate
+	files         *fileSet
+	flushInterval time.Duration // 有缓存时定期写入文件
+	fsync         FsyncMode
+	fsyncLevel    Level
+	fsyncInterval time.Duration
+	watchInterval time.Duration // 检查文件是否被外部工具修改
+	running       bool          // 后台定时任务是否在运行
+	quit          chan struct{}
+	done          chan struct{}
 }
 
 func (c *fileChannel) Name() string {
 	return "file"
 }
 
-func (c *fileChannel) Open() error {
-	if c.file == nil && c.err == nil {
-		if c.path == "" {
-			c.path = fmt.Sprintf("%s.log", filepath.Base(os.Args[0]))
+// static 返回不依赖Entry的路径
+func (c *fileChannel) static() []*pathTemplate {
+	var res []*pathTemplate
+	if !c.main.dynamic {
+		res = append(res, c.main)
+	}
+	for _, lt := range c.levels {
+		if !lt.path.dynamic {
+			res = append(res, lt.path)
 		}
+	}
+	return res
+}
 
-		dir := path.Dir(c.path)
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			c.err = err
-			return err
+func (c *fileChannel) Open() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.openStatic()
+}
+
+// openStatic 打开不依赖Entry的文件
+func (c *fileChannel) openStatic() error {
+	var res error
+	for _, t := range c.static() {
+		if err := c.open(c.files.get(t.raw)); err != nil && res == nil {
+			res = err
 		}
-		c.file, err = os.OpenFile(c.path, os.O_RDWR|os.O_CREATE, os.ModePerm)
-		if err != nil {
-			c.err = err
-			c.file = nil
+	}
+	return res
+}
+
+// open 打开失败后不再重试,直到Reopen或HealthCheck
+func (c *fileChannel) open(f *logFile) error {
+	if f.file == nil && f.err == nil {
+		if err := f.open(); err != nil {
+			f.err = err
+			c.HandleError(err, nil)
 		}
 	}
 
-	if c.file == nil {
+	if f.file == nil {
 		return ErrNotReady
 	}
 
+	if !c.running && (c.flushInterval > 0 || c.fsyncInterval > 0 || c.watchInterval > 0) {
+		c.running = true
+		c.quit = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.run(c.quit, c.done)
+	}
+
 	return nil
 }
 
+// run 定期写入缓存,同步到磁盘和检查文件
+func (c *fileChannel) run(quit, done chan struct{}) {
+	defer close(done)
+	var flushC, fsyncC, watchC <-chan time.Time
+	if c.flushInterval > 0 {
+		t := time.NewTicker(c.flushInterval)
+		defer t.Stop()
+		flushC = t.C
+	}
+	if c.fsyncInterval > 0 {
+		t := time.NewTicker(c.fsyncInterval)
+		defer t.Stop()
+		fsyncC = t.C
+	}
+	if c.watchInterval > 0 {
+		t := time.NewTicker(c.watchInterval)
+		defer t.Stop()
+		watchC = t.C
+	}
+
+	for {
+		var fn func(f *logFile) error
+		select {
+		case <-quit:
+			return
+		case <-flushC:
+			fn = (*logFile).flushLatest
+		case <-fsyncC:
+			fn = (*logFile).sync
+		case <-watchC:
+			fn = func(f *logFile) error {
+				_, err := f.watch()
+				return err
+			}
+		}
+		c.mux.Lock()
+		err := c.files.each(fn)
+		c.mux.Unlock()
+		if err != nil {
+			c.HandleError(err, nil)
+		}
+	}
+}
+
+// Close 关闭文件并等待后台的压缩和清理完成
 func (c *fileChannel) Close() error {
-	if c.file != nil {
-		err := c.file.Close()
-		c.file = nil
+	c.mux.Lock()
+	if c.running {
+		c.running = false
+		close(c.quit)
+		c.mux.Unlock()
+		<-c.done
+		c.mux.Lock()
+	}
+	err := c.files.each((*logFile).closeLatest)
+	c.mux.Unlock()
+	c.files.mill.wait()
+	return err
+}
+
+// Sync 写入缓存并同步到磁盘
+func (c *fileChannel) Sync() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.files.each((*logFile).sync)
+}
+
+// Reopen 关闭并重新打开文件,用于外部工具切割日志后
+func (c *fileChannel) Reopen() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_ = c.files.each(func(f *logFile) error {
+		f.err = nil
+		return f.closeLatest()
+	})
+	return c.openStatic()
+}
+
+// Rotate 立即切割所有打开的文件
+func (c *fileChannel) Rotate() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err := c.openStatic(); err != nil {
 		return err
 	}
+	return c.files.each(func(f *logFile) error {
+		if f.file == nil {
+			return nil
+		}
+		return f.rotate()
+	})
+}
 
-	return nil
+// HealthCheck 重新尝试打开文件
+func (c *fileChannel) HealthCheck() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_ = c.files.each(func(f *logFile) error {
+		if f.file == nil {
+			f.err = nil
+		}
+		return nil
+	})
+	return c.openStatic()
 }
 
 func (c *fileChannel) Write(e *Entry) {
-	if c.Open() == nil {
-		text := c.Format(e)
-		if text != nil {
-			_, _ = c.file.Write(text)
+	if err := c.TryWrite(e); err != nil && err != ErrNotReady {
+		c.HandleError(err, e)
+	}
+}
+
+// TryWrite 写入Entry对应的所有文件,返回第一个错误
+func (c *fileChannel) TryWrite(e *Entry) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	text := c.Format(e)
+	if text == nil {
+		return nil
+	}
+	// 文件不需要颜色,删除Layout中%highlight和%color输出的颜色
+	if bytes.IndexByte(text, 0x1b) != -1 {
+		b := NewBuffer()
+		defer b.Free()
+		text = stripColor(b, text)
+	}
+
+	res := c.write(c.main, e, text)
+	for _, lt := range c.levels {
+		if e.Level <= lt.level {
+			if err := c.write(lt.path, e, text); err != nil && res == nil {
+				res = err
+			}
 		}
 	}
+	return res
+}
+
+func (c *fileChannel) write(t *pathTemplate, e *Entry, text []byte) error {
+	f := c.files.get(t.resolve(e))
+	if err := c.open(f); err != nil {
+		return err
+	}
+	if _, err := f.write(text); err != nil {
+		return err
+	}
+	if c.fsync == FsyncAlways || (c.fsync == FsyncOnLevel && e.Level <= c.fsyncLevel) {
+		return f.sync()
+	}
+	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 )
 
 const (
@@ -33,11 +34,13 @@ func NewGraylogChannel(opts ...ChannelOption) Channel {
 	o := NewChannelOptions(opts...)
 	c := &graylogChannel{}
 	c.Init(o)
+	c.name = c.Name()
 	c.compressLevel = o.CompressLevel
 	c.compressType = o.CompressType
 	if c.compressLevel == -1 {
 		c.compressLevel = flate.BestSpeed
 	}
+	c.retry = o.Retry
 	c.localIP = o.LocalIP
 	if c.localIP == "" {
 		c.localIP = getLocalIp()
@@ -65,7 +68,10 @@ type graylogChannel struct {
 	addr          string       // Graylog连接地址
 	compressLevel int          // 压缩级别
 	compressType  CompressType // 压缩类型
+	retry         RetryOptions // TCP发送失败时重连并重试
+	mux           sync.Mutex   // 保护conn,发送时重连会修改
 	conn          net.Conn
+	closed        bool // 关闭后不再重连
 }
 
 func (c *graylogChannel) Name() string {
@@ -73,33 +79,67 @@ func (c *graylogChannel) Name() string {
 }
 
 func (c *graylogChannel) Open() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closed = false
+	if err := c.dial(); err != nil {
+		c.HandleError(fmt.Errorf("open graylog %s fail: %w", c.addr, err), nil)
+		return err
+	}
+
+	return nil
+}
+
+func (c *graylogChannel) dial() error {
 	if c.conn == nil && c.addr != "" {
-		if conn, err := net.Dial(c.network, c.addr); err != nil {
-			fmt.Printf("open graylog fail,%+v", c.addr)
+		conn, err := net.Dial(c.network, c.addr)
+		if err != nil {
 			return err
-		} else {
-			c.conn = conn
 		}
+		c.conn = conn
 	}
 
 	return nil
 }
 
+// HealthCheck 尝试连接,UDP只检查地址是否有效
+func (c *graylogChannel) HealthCheck() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.addr == "" {
+		return ErrNotReady
+	}
+	return c.dial()
+}
+
 func (c *graylogChannel) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closed = true
+	c.close()
+	return nil
+}
+
+func (c *graylogChannel) close() {
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
-
-	return nil
 }
 
 func (c *graylogChannel) Write(e *Entry) {
-	if c.conn == nil {
-		return
+	if err := c.TryWrite(e); err != nil && err != ErrNotReady {
+		c.HandleError(err, e)
+	}
+}
+
+func (c *graylogChannel) TryWrite(e *Entry) error {
+	if c.addr == "" {
+		return ErrNotReady
 	}
 
 	enc := NewJsonEncoder()
+	defer enc.Free()
 	enc.Begin()
 	enc.AddString("version", "1.1")
 	enc.AddString("host", c.localIP)
@@ -126,22 +166,67 @@ func (c *graylogChannel) Write(e *Entry) {
 	switch c.network {
 	case "tcp":
 		// GELF TCP不支持压缩,以\0分隔
-		// 同步阻塞发送
+		// 同步阻塞发送,失败时关闭连接,按照重试策略重连后再次发送,等待重试时不加锁
 		data := enc.Bytes()
-		c.conn.Write(data)
-		c.conn.Write(graylogTcpDelimited)
+		return c.retry.Do(func() error {
+			c.mux.Lock()
+			defer c.mux.Unlock()
+			return c.writeTcp(data)
+		})
 	case "udp":
-		data := c.compress(enc.Bytes())
-		var err error
-		if len(data) <= chunkedSize {
-			_, err = c.conn.Write(data)
-		} else {
-			err = c.writeChunked(data)
-		}
+		data, buf, err := c.compress(enc.Bytes())
 		if err != nil {
-			fmt.Printf("send graylog fail,%+v", err)
+			return err
+		}
+		if buf != nil {
+			defer buf.Free()
 		}
+		c.mux.Lock()
+		defer c.mux.Unlock()
+		return c.writeUdp(data)
 	}
+
+	return nil
+}
+
+func (c *graylogChannel) writeTcp(data []byte) error {
+	// TCP在发送时重连,关闭后不再重连
+	if c.closed {
+		return ErrNotReady
+	}
+	if err := c.dial(); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	if err == nil {
+		_, err = c.conn.Write(graylogTcpDelimited)
+	}
+	if err != nil {
+		c.close()
+	}
+
+	return err
+}
+
+func (c *graylogChannel) writeUdp(data []byte) error {
+	// 与TCP相同,连接失败时在发送时重连,关闭后不再重连
+	if c.closed {
+		return ErrNotReady
+	}
+	if err := c.dial(); err != nil {
+		return err
+	}
+	var err error
+	if len(data) <= chunkedSize {
+		_, err = c.conn.Write(data)
+	} else {
+		err = c.writeChunked(data)
+	}
+	if err != nil {
+		c.close()
+	}
+
+	return err
 }
 
 func (c *graylogChannel) writeChunked(data []byte) error {
@@ -197,8 +282,8 @@ func (c *graylogChannel) writeChunked(data []byte) error {
 	return nil
 }
 
-func (c *graylogChannel) compress(data []byte) []byte {
-	fmt.Printf("%s\n", data)
+// compress 压缩数据,若使用了Buffer则一同返回,使用完需要Free
+func (c *graylogChannel) compress(data []byte) ([]byte, *Buffer, error) {
 	var buf *Buffer
 	var w io.WriteCloser
 	var err error
@@ -210,16 +295,24 @@ func (c *graylogChannel) compress(data []byte) []byte {
 		buf = NewBuffer()
 		w, err = zlib.NewWriterLevel(buf, c.compressLevel)
 	default:
-		return data
+		return data, nil, nil
+	}
+	if err != nil {
+		buf.Free()
+		return nil, nil, err
 	}
 
 	buf.Grow(len(data))
 	if _, err = w.Write(data); err != nil {
 		w.Close()
-		return nil
+		buf.Free()
+		return nil, nil, err
+	}
+	if err = w.Close(); err != nil {
+		buf.Free()
+		return nil, nil, err
 	}
-	w.Close()
-	return buf.Bytes()
+	return buf.Bytes(), buf, nil
 }
 
 func toGraylogExtraKey(key string) string {
@@ -1,6 +1,10 @@
 package glog
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
 
 const (
 	// CompressNone .
@@ -15,41 +19,73 @@ type CompressType int
 
 // ChannelOptions Channel常见可选配置
 type ChannelOptions struct {
-	Level         Level        // 日志级别
-	Layout        string       // Text Format输出格式
-	Formatter     Formatter    // 输出格式
-	File          string       // 文件输出路径
-	URL           string       // 连接用URL,支持scheme为tcp或udp
-	LocalIP       string       // 本地地址
-	CompressLevel int          // 压缩级别
-	CompressType  CompressType // 压缩类型
-	HttpClient    *http.Client // elastic使用http协议
-	Retry         int          // 重试次数
-	Batch         int          // 一次发送大小
-	IndexName     string       // elastic索引名
+	Level          Level          // 日志级别
+	MaxLevel       Level          // 最高的日志级别,比如InfoLevel时不输出Warn及以上的日志,默认PanicLevel不限制
+	Filters        []Filter       // 只对该Channel生效的Filter,修改的是Entry的副本
+	Layout         string         // Text Format输出格式
+	Formatter      Formatter      // 输出格式
+	File           string         // 文件输出路径
+	MaxSize        int64          // 单个日志文件的最大字节数,超过时切割,0表示不切割
+	MaxBackups     int            // 切割后最多保留的备份数,0表示不限制
+	BackupNaming   BackupNaming   // 备份文件命名方式,序号或时间
+	TimeZone       *time.Location // 按时间切割时计算周期的时区,默认使用路径中指定的时区或本地时区
+	Symlink        string         // 指向当前日志文件的软链接
+	MaxAge         time.Duration  // 切割后的文件最长保留时间,0表示不限制
+	MaxTotalSize   int64          // 切割后的文件最多占用的字节数,0表示不限制
+	RotateHook     RotateHook     // 每次切割后调用,比如上传到对象存储
+	FlushInterval  time.Duration  // 有缓存时定期写入文件的间隔,默认1s
+	Fsync          FsyncMode      // 同步到磁盘的策略,默认不主动同步
+	FsyncInterval  time.Duration  // FsyncInterval时同步的间隔
+	FsyncLevel     Level          // FsyncOnLevel时不低于该级别的日志写入后立即同步
+	WatchInterval  time.Duration  // 检查文件是否被外部工具移动,删除或截断的间隔,0表示不检查
+	LevelFiles     []LevelFile    // 额外输出不低于某个级别的日志的文件
+	MaxOpenFiles   int            // 拆分文件时最多打开的文件数,默认64,不依赖Entry的文件不会被关闭
+	Shared         bool           // 多个进程写入同一个文件,切割时通过文件锁协调
+	URL            string         // 连接用URL,支持scheme为tcp或udp
+	LocalIP        string         // 本地地址
+	CompressLevel  int            // 压缩级别
+	CompressType   CompressType   // 压缩类型
+	HttpClient     *http.Client   // elastic使用http协议
+	Retry          RetryOptions   // 失败重试策略
+	Batch          int            // 一次发送大小
+	BatchBytes     int            // 一次发送的最大字节数
+	Linger         time.Duration  // 未达到Batch时,最长等待时间
+	IndexName      string         // elastic索引名
+	Async          AsyncOptions   // Async.LogMax大于0时,使用独立的异步队列
+	SegmentBytes   int64          // spool单个文件的最大字节数
+	ReplayInterval time.Duration  // spool重新发送的检查间隔
+	SlowWrite      time.Duration  // spool中inner单次写入超过该时间时,之后的日志先写入磁盘,默认1s,负数表示不检查
+	BufferSize     int            // 写入缓存大小,0表示不缓存,每条日志直接写入
+	LineBuffered   bool           // 有缓存时每条日志写入后立即刷新
+	NoLock         bool           // Writer本身并发安全时不加锁
+	Stderr         bool           // console输出到stderr
+	Split          bool           // console按照级别分别输出到stdout和stderr
+	SplitLevel     Level          // Split时不低于该级别的日志输出到stderr
+	ColorScheme    *ColorScheme   // console整行着色时的配色,默认DefaultColorScheme
 }
 
 type ChannelOption func(o *ChannelOptions)
 
 // NewChannelOptions ...
 func NewChannelOptions(opts ...ChannelOption) *ChannelOptions {
-	o := &ChannelOptions{Level: TraceLevel}
+	o := &ChannelOptions{Level: TraceLevel, CompressLevel: -1, CompressType: CompressNone}
 	for _, fn := range opts {
 		fn(o)
 	}
 
 	if o.Formatter == nil {
 		if o.Layout != "" {
-			o.Formatter, _ = NewTextFormatter(o.Layout)
+			f, err := NewTextFormatter(o.Layout)
+			if err != nil {
+				DefaultErrorHandler("", fmt.Errorf("invalid layout %q: %w", o.Layout, err), nil)
+			}
+			o.Formatter = f
 		}
 		if o.Formatter == nil {
 			o.Formatter = DefaultFormatter
 		}
 	}
 
-	o.CompressLevel = -1
-	o.CompressType = CompressNone
-
 	return o
 }
 
@@ -59,6 +95,20 @@ func WithLevel(lv Level) ChannelOption {
 	}
 }
 
+// WithMaxLevel 设置最高的日志级别,与WithLevel一起可以只输出一个范围内的日志
+func WithMaxLevel(lv Level) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.MaxLevel = lv
+	}
+}
+
+// WithFilters 添加只对该Channel生效的Filter
+func WithFilters(filters ...Filter) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Filters = append(o.Filters, filters...)
+	}
+}
+
 func WithLayout(l string) ChannelOption {
 	return func(o *ChannelOptions) {
 		o.Layout = l
@@ -71,6 +121,127 @@ func WithFormatter(f Formatter) ChannelOption {
 	}
 }
 
+// WithFile 设置文件输出路径,默认为程序名.log
+func WithFile(path string) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.File = path
+	}
+}
+
+// WithMaxSize 设置单个日志文件的最大字节数,超过时切割
+func WithMaxSize(n int64) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.MaxSize = n
+	}
+}
+
+// WithMaxBackups 设置最多保留的备份数
+func WithMaxBackups(n int) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.MaxBackups = n
+	}
+}
+
+// WithBackupNaming 设置备份文件命名方式
+func WithBackupNaming(n BackupNaming) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.BackupNaming = n
+	}
+}
+
+// WithTimeZone 设置按时间切割的时区
+func WithTimeZone(loc *time.Location) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.TimeZone = loc
+	}
+}
+
+// WithSymlink 设置指向当前日志文件的软链接,比如logs/app.log
+func WithSymlink(link string) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Symlink = link
+	}
+}
+
+// WithMaxAge 设置切割后的文件最长保留时间
+func WithMaxAge(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.MaxAge = d
+	}
+}
+
+// WithMaxTotalSize 设置切割后的文件最多占用的字节数,超出时从最旧的文件开始删除
+func WithMaxTotalSize(n int64) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.MaxTotalSize = n
+	}
+}
+
+// WithRotateHook 设置切割后的回调,开启压缩时在压缩完成后调用
+func WithRotateHook(hook RotateHook) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.RotateHook = hook
+	}
+}
+
+// WithFlushInterval 设置有缓存时定期写入文件的间隔,缓存大小通过WithBufferSize设置
+func WithFlushInterval(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.FlushInterval = d
+	}
+}
+
+// WithFsyncInterval 定期同步到磁盘
+func WithFsyncInterval(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Fsync = FsyncInterval
+		o.FsyncInterval = d
+	}
+}
+
+// WithFsyncAlways 每条日志写入后同步到磁盘
+func WithFsyncAlways() ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Fsync = FsyncAlways
+	}
+}
+
+// WithFsyncLevel 不低于lv的日志写入后同步到磁盘,比如ErrorLevel
+func WithFsyncLevel(lv Level) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Fsync = FsyncOnLevel
+		o.FsyncLevel = lv
+	}
+}
+
+// WithWatchInterval 定期检查文件是否被外部工具移动,删除或截断,比如logrotate,移动或删除后重新打开
+func WithWatchInterval(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.WatchInterval = d
+	}
+}
+
+// WithLevelFile 不低于lv的日志同时写入path,比如WithLevelFile(ErrorLevel, "logs/error.log")
+func WithLevelFile(lv Level, path string) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.LevelFiles = append(o.LevelFiles, LevelFile{Level: lv, Path: path})
+	}
+}
+
+// WithMaxOpenFiles 设置拆分文件时最多打开的文件数,超过时关闭最久未使用的文件
+func WithMaxOpenFiles(n int) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.MaxOpenFiles = n
+	}
+}
+
+// WithShared 多个进程写入同一个文件,每条日志以一次追加写入,切割和清理时加文件锁,只有一个进程切割,其他进程重新打开
+func WithShared() ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Shared = true
+	}
+}
+
 func WithURL(url string) ChannelOption {
 	return func(o *ChannelOptions) {
 		o.URL = url
@@ -102,9 +273,25 @@ func WithCompress(ct CompressType, level int) ChannelOption {
 	}
 }
 
+// WithRetry 设置最大重试次数,使用默认的退避参数
 func WithRetry(retry int) ChannelOption {
 	return func(o *ChannelOptions) {
-		o.Retry = retry
+		o.Retry.Max = retry
+	}
+}
+
+// WithBackoff 设置重试的初始等待时间和单次等待上限
+func WithBackoff(initial, maxDelay time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Retry.Initial = initial
+		o.Retry.MaxDelay = maxDelay
+	}
+}
+
+// WithRetryOptions 设置全部重试策略
+func WithRetryOptions(r RetryOptions) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Retry = r
 	}
 }
 
@@ -120,8 +307,108 @@ func WithBatch(b int) ChannelOption {
 	}
 }
 
+// WithBatchBytes 设置一次批量发送的最大字节数
+func WithBatchBytes(n int) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.BatchBytes = n
+	}
+}
+
+// WithLinger 设置批量发送的最长等待时间
+func WithLinger(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Linger = d
+	}
+}
+
 func WithIndexName(index string) ChannelOption {
 	return func(o *ChannelOptions) {
 		o.IndexName = index
 	}
 }
+
+// WithSegmentBytes 设置spool单个文件的最大字节数
+func WithSegmentBytes(n int64) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.SegmentBytes = n
+	}
+}
+
+// WithReplayInterval 设置spool重新发送的检查间隔
+func WithReplayInterval(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.ReplayInterval = d
+	}
+}
+
+// WithSlowWrite 设置spool认为inner处理不过来的写入时间,负数表示不检查
+func WithSlowWrite(d time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.SlowWrite = d
+	}
+}
+
+// WithBufferSize 设置写入缓存大小,缓存满,Sync或者Close时写入
+func WithBufferSize(n int) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.BufferSize = n
+	}
+}
+
+// WithLineBuffered 每条日志写入后立即刷新缓存,保证日志以完整的行输出
+func WithLineBuffered() ChannelOption {
+	return func(o *ChannelOptions) {
+		o.LineBuffered = true
+	}
+}
+
+// WithNoLock 不加锁,只在Writer本身并发安全且没有缓存时使用
+func WithNoLock() ChannelOption {
+	return func(o *ChannelOptions) {
+		o.NoLock = true
+	}
+}
+
+// WithStderr console输出到stderr
+func WithStderr() ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Stderr = true
+	}
+}
+
+// WithSplit console中不低于lv的日志输出到stderr,其他输出到stdout,比如WarnLevel
+func WithSplit(lv Level) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Split = true
+		o.SplitLevel = lv
+	}
+}
+
+// WithColorScheme 设置console整行着色时的配色
+func WithColorScheme(scheme ColorScheme) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.ColorScheme = &scheme
+	}
+}
+
+// WithAsync 使用独立的异步队列,慢速Channel不会影响其他Channel
+func WithAsync(queueSize int) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Async.LogMax = queueSize
+	}
+}
+
+// WithOverflow 设置独立异步队列满时的处理策略
+func WithOverflow(policy OverflowPolicy, timeout time.Duration) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Async.Overflow = policy
+		o.Async.Timeout = timeout
+	}
+}
+
+// WithAsyncOptions 设置独立异步队列的全部配置
+func WithAsyncOptions(async AsyncOptions) ChannelOption {
+	return func(o *ChannelOptions) {
+		o.Async = async
+	}
+}
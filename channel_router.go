@@ -0,0 +1,309 @@
+package glog
+
+import "regexp"
+
+// CategoryKey 日志分类的Field名,可通过Category添加
+const CategoryKey = "category"
+
+// Category 创建日志分类Field,用于路由,比如audit
+func Category(name string) Field {
+	return String(CategoryKey, name)
+}
+
+// Predicate 判断Entry是否匹配路由
+type Predicate func(e *Entry) bool
+
+// MatchLevels 匹配级别在[from, to]之间的日志,顺序无关
+func MatchLevels(from, to Level) Predicate {
+	if from > to {
+		from, to = to, from
+	}
+	return func(e *Entry) bool {
+		return e.Level >= from && e.Level <= to
+	}
+}
+
+// MatchTag 匹配Tag值
+func MatchTag(key, value string) Predicate {
+	return func(e *Entry) bool {
+		v, ok := e.Tags.Get(key)
+		return ok && v == value
+	}
+}
+
+// MatchField 匹配含有某个Field的日志
+func MatchField(key string) Predicate {
+	return func(e *Entry) bool {
+		return findField(e, key) != nil
+	}
+}
+
+// MatchFieldValue 匹配Field值,值转换为字符串后比较
+func MatchFieldValue(key, value string) Predicate {
+	return func(e *Entry) bool {
+		f := findField(e, key)
+		if f == nil {
+			return false
+		}
+		if f.Type == FieldTypeString {
+			return f.String == value
+		}
+		b := NewBuffer()
+		defer b.Free()
+		f.AppendValueToBuffer(b)
+		return string(b.Bytes()) == value
+	}
+}
+
+// MatchCategory 匹配日志分类,先查找Field,再查找Tag
+func MatchCategory(category string) Predicate {
+	field := MatchFieldValue(CategoryKey, category)
+	tag := MatchTag(CategoryKey, category)
+	return func(e *Entry) bool {
+		return field(e) || tag(e)
+	}
+}
+
+// MatchMessage 通过正则匹配日志内容,正则非法时panic
+func MatchMessage(pattern string) Predicate {
+	return MatchRegexp(regexp.MustCompile(pattern))
+}
+
+// MatchRegexp 通过正则匹配日志内容
+func MatchRegexp(re *regexp.Regexp) Predicate {
+	return func(e *Entry) bool {
+		return re.MatchString(e.Text)
+	}
+}
+
+// And 全部匹配
+func And(predicates ...Predicate) Predicate {
+	return func(e *Entry) bool {
+		for _, p := range predicates {
+			if !p(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or 任意一个匹配
+func Or(predicates ...Predicate) Predicate {
+	return func(e *Entry) bool {
+		for _, p := range predicates {
+			if p(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not 不匹配
+func Not(p Predicate) Predicate {
+	return func(e *Entry) bool {
+		return !p(e)
+	}
+}
+
+func findField(e *Entry, key string) *Field {
+	for i := range e.Fields {
+		if e.Fields[i].Key == key {
+			return &e.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Route 路由规则,Match为nil时为默认路由,只在其他路由都不匹配时使用
+type Route struct {
+	Match    Predicate
+	Channels []Channel
+}
+
+// NewRoute 创建路由
+func NewRoute(match Predicate, channels ...Channel) Route {
+	return Route{Match: match, Channels: channels}
+}
+
+// DefaultRoute 创建默认路由
+func DefaultRoute(channels ...Channel) Route {
+	return Route{Channels: channels}
+}
+
+const (
+	// RouteFirstMatch 只使用第一个匹配的路由,默认
+	RouteFirstMatch RouteMode = iota
+	// RouteAllMatch 使用所有匹配的路由,同一个Channel只写入一次
+	RouteAllMatch
+)
+
+// RouteMode 路由匹配方式
+type RouteMode int
+
+// NewRouterChannel 创建路由Channel,按照顺序匹配,只使用第一个匹配的路由
+// 比如审计日志只写入审计文件,其他日志写入graylog:
+// NewRouterChannel(NewRoute(MatchCategory("audit"), auditFile), DefaultRoute(graylog))
+func NewRouterChannel(routes ...Route) Channel {
+	return NewRouterChannelWithMode(RouteFirstMatch, routes...)
+}
+
+// NewRouterChannelWithMode 创建路由Channel并指定匹配方式
+func NewRouterChannelWithMode(mode RouteMode, routes ...Route) Channel {
+	c := &routerChannel{mode: mode}
+	c.Init(NewChannelOptions())
+	c.name = c.Name()
+	for _, r := range routes {
+		if r.Match == nil {
+			c.defaults = append(c.defaults, r.Channels...)
+		} else {
+			c.routes = append(c.routes, r)
+		}
+		for _, ch := range r.Channels {
+			if !containsChannel(c.channels, ch) {
+				c.channels = append(c.channels, ch)
+			}
+		}
+	}
+	return c
+}
+
+// routerChannel 根据Entry内容分发到不同的Channel
+type routerChannel struct {
+	BaseChannel
+	mode     RouteMode
+	routes   []Route
+	defaults []Channel
+	channels []Channel // 所有Channel,去重
+}
+
+func (c *routerChannel) Name() string {
+	return "router"
+}
+
+// setup 同时注入所有Channel的配置
+func (c *routerChannel) setup(name string, conf *Config) {
+	c.BaseChannel.setup(name, conf)
+	for _, ch := range c.channels {
+		if cs, ok := ch.(channelSetup); ok {
+			cs.setup(ch.Name(), conf)
+		}
+	}
+}
+
+// IsEnable 任意一个Channel需要时返回true
+func (c *routerChannel) IsEnable(lv Level) bool {
+	for _, ch := range c.channels {
+		if ch.IsEnable(lv) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *routerChannel) Open() error {
+	var res error
+	for _, ch := range c.channels {
+		if err := ch.Open(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (c *routerChannel) Close() error {
+	var res error
+	for _, ch := range c.channels {
+		if err := ch.Close(); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
+
+func (c *routerChannel) Sync() error {
+	var res error
+	for _, ch := range c.channels {
+		if s, ok := ch.(Syncer); ok {
+			if err := s.Sync(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+func (c *routerChannel) Reopen() error {
+	var res error
+	for _, ch := range c.channels {
+		if r, ok := ch.(Reopener); ok {
+			if err := r.Reopen(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+func (c *routerChannel) Write(e *Entry) {
+	c.route(e, func(ch Channel) error {
+		writeChannel(ch, e)
+		return nil
+	})
+}
+
+// TryWrite 返回第一个写入错误
+func (c *routerChannel) TryWrite(e *Entry) error {
+	return c.route(e, func(ch Channel) error {
+		return tryWrite(ch, e)
+	})
+}
+
+// route 查找匹配的Channel并写入
+func (c *routerChannel) route(e *Entry, write func(ch Channel) error) error {
+	var res error
+	var matched []Channel
+	hit := false
+	for _, r := range c.routes {
+		if !r.Match(e) {
+			continue
+		}
+		hit = true
+		for _, ch := range r.Channels {
+			if containsChannel(matched, ch) {
+				continue
+			}
+			matched = append(matched, ch)
+			if ch.IsEnable(e.Level) {
+				if err := write(ch); err != nil && res == nil {
+					res = err
+				}
+			}
+		}
+		if c.mode == RouteFirstMatch {
+			return res
+		}
+	}
+
+	if !hit {
+		for _, ch := range c.defaults {
+			if ch.IsEnable(e.Level) {
+				if err := write(ch); err != nil && res == nil {
+					res = err
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+func containsChannel(channels []Channel, c Channel) bool {
+	for _, ch := range channels {
+		if ch == c {
+			return true
+		}
+	}
+	return false
+}
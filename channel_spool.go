@@ -0,0 +1,608 @@
+package glog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSpoolSegmentBytes spool单个文件的默认最大字节数
+	DefaultSpoolSegmentBytes = 16 * 1024 * 1024
+	// DefaultSpoolReplayInterval spool默认的重新发送检查间隔
+	DefaultSpoolReplayInterval = time.Second
+	// DefaultSpoolSlowWrite inner单次写入超过该时间时认为处理不过来,之后的日志先写入磁盘
+	DefaultSpoolSlowWrite = time.Second
+)
+
+const (
+	spoolExt             = ".spool"
+	spoolCheckpoint      = "checkpoint"
+	spoolCheckpointEvery = 64 // 每发送多少条保存一次进度
+)
+
+// NewSpoolChannel 创建磁盘缓存Channel,inner写入失败或处理不过来时把日志追加到dir下的分段文件中,
+// 恢复后按照顺序重新发送,进程重启后会继续发送未完成的日志
+// maxBytes为磁盘占用上限,超过时删除最旧的分段文件并报告丢弃的日志数,小于等于0表示不限制
+// inner需要实现TryWriter才能知道写入是否失败,Context不会保存
+func NewSpoolChannel(inner Channel, dir string, maxBytes int64, opts ...ChannelOption) Channel {
+	o := NewChannelOptions(opts...)
+	c := &spoolChannel{inner: inner, dir: dir, maxBytes: maxBytes}
+	c.Init(o)
+	c.name = c.Name()
+	c.segmentBytes = o.SegmentBytes
+	if c.segmentBytes <= 0 {
+		c.segmentBytes = DefaultSpoolSegmentBytes
+	}
+	// 至少保留几个分段,删除时不会一次丢弃太多
+	if maxBytes > 0 && c.segmentBytes > maxBytes/4 {
+		c.segmentBytes = maxBytes / 4
+	}
+	c.interval = o.ReplayInterval
+	if c.interval <= 0 {
+		c.interval = DefaultSpoolReplayInterval
+	}
+	c.slowWrite = o.SlowWrite
+	if c.slowWrite == 0 {
+		c.slowWrite = DefaultSpoolSlowWrite
+	}
+	return c
+}
+
+// spoolSegment 分段文件
+type spoolSegment struct {
+	seq   uint64 // 序号,决定发送顺序
+	size  int64  // 文件大小
+	count int    // 日志条数
+}
+
+// spoolChannel 没有积压时直接写入inner,失败或者写入太慢后写入磁盘,由后台goroutine按顺序重新发送
+// 有积压时新日志也写入磁盘,保证顺序,同时写日志的goroutine不会被重新发送阻塞
+// 发送进度定期保存,异常退出后重启可能会重复发送少量日志
+type spoolChannel struct {
+	BaseChannel
+	inner        Channel
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	interval     time.Duration
+	slowWrite    time.Duration // 小于0表示不检查
+	mux          sync.Mutex
+	opened       bool
+	closed       bool            // Close后不再写入,直到再次Open
+	segments     []*spoolSegment // 按照seq排序,第一个正在发送,最后一个正在写入
+	total        int64           // 所有分段的大小
+	file         *os.File        // 正在写入的文件,非nil时对应最后一个分段
+	offset       int64           // 第一个分段已发送的字节数
+	index        int             // 第一个分段已发送的条数
+	committed    int             // 上次保存进度后发送的条数
+	failing      bool            // inner写入失败中,只在开始失败时报告
+	behind       bool            // inner写入太慢,之后的日志先写入磁盘,积压发送完后恢复
+	dropped      *Counter
+	quit         chan struct{}
+	done         chan struct{}
+}
+
+func (c *spoolChannel) Name() string {
+	return "spool_" + c.inner.Name()
+}
+
+// setup 同时注入inner的配置
+func (c *spoolChannel) setup(name string, conf *Config) {
+	c.BaseChannel.setup(name, conf)
+	if cs, ok := c.inner.(channelSetup); ok {
+		cs.setup(c.inner.Name(), conf)
+	}
+	m := conf.Metrics
+	c.dropped = m.Counter("glog_spool_dropped_total", "Number of spooled entries deleted because the spool exceeded its size limit.", "channel", name)
+	m.GaugeFunc("glog_spool_bytes", "Number of bytes waiting in the spool.", func() float64 {
+		return float64(c.Size())
+	}, "channel", name)
+}
+
+func (c *spoolChannel) IsEnable(lv Level) bool {
+	return c.BaseChannel.IsEnable(lv) && c.inner.IsEnable(lv)
+}
+
+// Size 返回磁盘中等待发送的字节数
+func (c *spoolChannel) Size() int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.total - c.offset
+}
+
+func (c *spoolChannel) Open() error {
+	c.mux.Lock()
+	c.closed = false
+	err := c.open()
+	c.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.inner.Open()
+}
+
+// open 加载已有的分段文件并开始重新发送
+func (c *spoolChannel) open() error {
+	if c.opened {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	c.opened = true
+	c.quit = make(chan struct{})
+	c.done = make(chan struct{})
+	go c.run(c.quit, c.done)
+	return nil
+}
+
+// Close 停止重新发送,保存进度后关闭inner,之后的写入返回ErrClosed
+func (c *spoolChannel) Close() error {
+	c.mux.Lock()
+	c.closed = true
+	if !c.opened {
+		c.mux.Unlock()
+		return c.inner.Close()
+	}
+	c.opened = false
+	close(c.quit)
+	c.mux.Unlock()
+	<-c.done
+
+	c.mux.Lock()
+	c.saveCheckpoint()
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+	c.segments = nil
+	c.total = 0
+	c.offset = 0
+	c.index = 0
+	c.failing = false
+	c.behind = false
+	c.mux.Unlock()
+
+	return c.inner.Close()
+}
+
+func (c *spoolChannel) Sync() error {
+	c.mux.Lock()
+	if c.file != nil {
+		_ = c.file.Sync()
+	}
+	c.mux.Unlock()
+	if s, ok := c.inner.(Syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (c *spoolChannel) Reopen() error {
+	if r, ok := c.inner.(Reopener); ok {
+		return r.Reopen()
+	}
+	return nil
+}
+
+func (c *spoolChannel) Write(e *Entry) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.closed {
+		c.HandleError(ErrClosed, e)
+		return
+	}
+	// 无法使用磁盘时直接写入inner
+	if err := c.open(); err != nil {
+		c.HandleError(err, e)
+		c.mux.Unlock()
+		writeChannel(c.inner, e)
+		c.mux.Lock()
+		return
+	}
+
+	if len(c.segments) == 0 && !c.behind {
+		c.mux.Unlock()
+		start := time.Now()
+		err := tryWrite(c.inner, e)
+		elapsed := time.Since(start)
+		c.mux.Lock()
+		if c.closed {
+			// 写入期间被关闭,不能再创建分段
+			if err != nil {
+				c.HandleError(err, e)
+			}
+			return
+		}
+		if err == nil {
+			if c.slowWrite > 0 && elapsed > c.slowWrite && !c.behind {
+				c.behind = true
+				c.HandleError(fmt.Errorf("spool: %s write took %v, spooling to disk", c.inner.Name(), elapsed), nil)
+			}
+			return
+		}
+		if !c.failing {
+			c.failing = true
+			c.HandleError(fmt.Errorf("spool: %s write fail, spooling to disk: %w", c.inner.Name(), err), e)
+		}
+	}
+
+	if err := c.append(e); err != nil {
+		c.HandleError(err, e)
+	}
+}
+
+// append 追加到最后一个分段,超过上限时删除最旧的分段
+func (c *spoolChannel) append(e *Entry) error {
+	data, err := encodeSpoolRecord(e)
+	if err != nil {
+		return err
+	}
+
+	if c.file == nil || c.segments[len(c.segments)-1].size >= c.segmentBytes {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+
+	seg := c.segments[len(c.segments)-1]
+	n, err := c.file.Write(data)
+	seg.size += int64(n)
+	c.total += int64(n)
+	if err != nil {
+		return err
+	}
+	seg.count++
+	c.trim()
+	return nil
+}
+
+// rotate 创建新的分段
+func (c *spoolChannel) rotate() error {
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+
+	seq := uint64(1)
+	if n := len(c.segments); n > 0 {
+		seq = c.segments[n-1].seq + 1
+	}
+	f, err := os.OpenFile(c.segmentPath(seq), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.file = f
+	c.segments = append(c.segments, &spoolSegment{seq: seq})
+	return nil
+}
+
+// trim 超过maxBytes时删除最旧的分段,正在写入的分段不会删除
+func (c *spoolChannel) trim() {
+	for c.maxBytes > 0 && c.total > c.maxBytes && len(c.segments) > 1 {
+		n := c.segments[0].count - c.index
+		c.removeHead()
+		c.dropped.Add(uint64(n))
+		c.HandleError(fmt.Errorf("spool: size exceeds %d bytes, dropped %d oldest entries", c.maxBytes, n), nil)
+	}
+}
+
+// removeHead 删除第一个分段,如果同时是正在写入的分段则先关闭
+func (c *spoolChannel) removeHead() {
+	head := c.segments[0]
+	if len(c.segments) == 1 && c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+	if err := os.Remove(c.segmentPath(head.seq)); err != nil && !os.IsNotExist(err) {
+		c.HandleError(err, nil)
+	}
+	c.segments = c.segments[1:]
+	c.total -= head.size
+	c.offset = 0
+	c.index = 0
+	c.saveCheckpoint()
+}
+
+func (c *spoolChannel) segmentPath(seq uint64) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%020d%s", seq, spoolExt))
+}
+
+// load 加载已有的分段和发送进度
+func (c *spoolChannel) load() error {
+	infos, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	c.segments = nil
+	c.total = 0
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.dir, name)
+		if fi.Size() == 0 {
+			_ = os.Remove(path)
+			continue
+		}
+		count, err := countLines(path)
+		if err != nil {
+			return err
+		}
+		c.segments = append(c.segments, &spoolSegment{seq: seq, size: fi.Size(), count: count})
+		c.total += fi.Size()
+	}
+	sort.Slice(c.segments, func(i, j int) bool {
+		return c.segments[i].seq < c.segments[j].seq
+	})
+
+	c.offset = 0
+	c.index = 0
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, spoolCheckpoint))
+	if err != nil {
+		return nil
+	}
+	var seq uint64
+	var offset int64
+	var index int
+	if _, err := fmt.Sscanf(string(data), "%d %d %d", &seq, &offset, &index); err != nil {
+		return nil
+	}
+	// 删除已经发送完,但退出前没来得及删除的分段
+	for len(c.segments) > 0 && c.segments[0].seq < seq {
+		c.removeHead()
+	}
+	if len(c.segments) > 0 && c.segments[0].seq == seq && offset <= c.segments[0].size {
+		c.offset = offset
+		c.index = index
+	}
+
+	return nil
+}
+
+// countLines 分块读取文件并统计行数,最后一行没有换行符时也计入
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	count, last := 0, byte('\n')
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			count += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last != '\n' {
+		count++
+	}
+	return count, nil
+}
+
+// saveCheckpoint 保存发送进度,格式为"seq offset index"
+func (c *spoolChannel) saveCheckpoint() {
+	c.committed = 0
+	path := filepath.Join(c.dir, spoolCheckpoint)
+	if len(c.segments) == 0 {
+		_ = os.Remove(path)
+		return
+	}
+
+	data := fmt.Sprintf("%d %d %d\n", c.segments[0].seq, c.offset, c.index)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+		c.HandleError(err, nil)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		c.HandleError(err, nil)
+	}
+}
+
+func (c *spoolChannel) run(quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			c.replay(quit)
+		}
+	}
+}
+
+// replay 按顺序重新发送,inner失败时等待下次检查
+func (c *spoolChannel) replay(quit chan struct{}) {
+	var rd *os.File
+	var rdSeq uint64
+	defer func() {
+		if rd != nil {
+			rd.Close()
+		}
+	}()
+
+	for {
+		c.mux.Lock()
+		if len(c.segments) == 0 {
+			c.behind = false
+			c.mux.Unlock()
+			return
+		}
+		head := c.segments[0]
+		offset, size := c.offset, head.size
+		if offset >= size {
+			// 全部发送完成时回到直接写入模式
+			if len(c.segments) == 1 {
+				c.failing = false
+				c.behind = false
+			}
+			c.removeHead()
+			c.mux.Unlock()
+			continue
+		}
+		c.mux.Unlock()
+
+		if rd == nil || rdSeq != head.seq {
+			if rd != nil {
+				rd.Close()
+			}
+			var err error
+			if rd, err = os.Open(c.segmentPath(head.seq)); err != nil {
+				c.HandleError(err, nil)
+				return
+			}
+			rdSeq = head.seq
+		}
+
+		r := bufio.NewReader(io.NewSectionReader(rd, offset, size-offset))
+		for {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+
+			line, _ := r.ReadBytes('\n')
+			if len(line) == 0 {
+				break
+			}
+			e, err := decodeSpoolRecord(line)
+			if err != nil {
+				c.HandleError(fmt.Errorf("spool: skip invalid record: %w", err), nil)
+			} else {
+				err = tryWrite(c.inner, e)
+				e.Free()
+				if err != nil {
+					return
+				}
+			}
+			if !c.commit(head, int64(len(line))) {
+				break
+			}
+		}
+	}
+}
+
+// commit 记录发送进度,分段已经被删除时返回false
+func (c *spoolChannel) commit(head *spoolSegment, n int64) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.segments) == 0 || c.segments[0] != head {
+		return false
+	}
+	c.offset += n
+	c.index++
+	c.committed++
+	if c.committed >= spoolCheckpointEvery {
+		c.saveCheckpoint()
+	}
+	return true
+}
+
+// spoolRecord 磁盘中保存的日志,每条一行json
+type spoolRecord struct {
+	Time   time.Time    `json:"time"`
+	Level  Level        `json:"level"`
+	Text   string       `json:"text"`
+	Tags   []KV         `json:"tags,omitempty"`
+	Fields []spoolField `json:"fields,omitempty"`
+	Host   string       `json:"host,omitempty"`
+	Path   string       `json:"path,omitempty"`
+	File   string       `json:"file,omitempty"`
+	Line   int          `json:"line,omitempty"`
+	Method string       `json:"method,omitempty"`
+}
+
+// spoolField Any类型的Field保存为字符串
+type spoolField struct {
+	Key    string    `json:"k"`
+	Type   FieldType `json:"t"`
+	Int    int64     `json:"i,omitempty"`
+	String string    `json:"s,omitempty"`
+}
+
+func encodeSpoolRecord(e *Entry) ([]byte, error) {
+	r := spoolRecord{
+		Time:   e.Time,
+		Level:  e.Level,
+		Text:   e.Text,
+		Tags:   e.Tags.items,
+		Host:   e.Host,
+		Path:   e.Path,
+		File:   e.File,
+		Line:   e.Line,
+		Method: e.Method,
+	}
+	for i := range e.Fields {
+		f := &e.Fields[i]
+		sf := spoolField{Key: f.Key, Type: f.Type, Int: f.Int, String: f.String}
+		if f.Type == FieldTypeAny {
+			b := NewBuffer()
+			f.AppendValueToBuffer(b)
+			sf = spoolField{Key: f.Key, Type: FieldTypeString, String: b.String()}
+			b.Free()
+		}
+		r.Fields = append(r.Fields, sf)
+	}
+
+	data, err := json.Marshal(&r)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func decodeSpoolRecord(line []byte) (*Entry, error) {
+	r := spoolRecord{}
+	if err := json.Unmarshal(line, &r); err != nil {
+		return nil, err
+	}
+
+	e := NewEntry(nil)
+	e.Time = r.Time
+	e.Level = r.Level
+	e.Text = r.Text
+	e.Tags.items = r.Tags
+	e.Host = r.Host
+	e.Path = r.Path
+	e.File = r.File
+	e.Line = r.Line
+	e.Method = r.Method
+	for _, f := range r.Fields {
+		e.Fields = append(e.Fields, Field{Key: f.Key, Type: f.Type, Int: f.Int, String: f.String})
+	}
+	return e, nil
+}
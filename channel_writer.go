@@ -0,0 +1,126 @@
+package glog
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// NewWriterChannel 创建输出到io.Writer的Channel,默认加锁,每条日志以完整的一行写入
+// 可通过WithBufferSize,WithLineBuffered,WithNoLock配置,Close时不会关闭w
+// w不支持颜色时(见ColorEnabled)会删除Layout输出的颜色
+func NewWriterChannel(w io.Writer, opts ...ChannelOption) Channel {
+	o := NewChannelOptions(opts...)
+	c := &writerChannel{out: newLineWriter(w, o)}
+	c.Init(o)
+	c.name = c.Name()
+	return c
+}
+
+// writerChannel 输出到io.Writer
+type writerChannel struct {
+	BaseChannel
+	out *lineWriter
+}
+
+func (c *writerChannel) Name() string {
+	return "writer"
+}
+
+func (c *writerChannel) Close() error {
+	return c.out.Flush()
+}
+
+func (c *writerChannel) Sync() error {
+	return c.out.Flush()
+}
+
+func (c *writerChannel) Write(e *Entry) {
+	if err := c.TryWrite(e); err != nil {
+		c.HandleError(err, e)
+	}
+}
+
+func (c *writerChannel) TryWrite(e *Entry) error {
+	text := c.Format(e)
+	if len(text) == 0 {
+		return nil
+	}
+	return c.out.WriteLine(text)
+}
+
+// lineWriter 以行为单位写入io.Writer,可选缓存和加锁
+type lineWriter struct {
+	mux   sync.Mutex
+	w     io.Writer
+	buf   *bufio.Writer // 非nil时使用缓存
+	line  bool          // 每行写入后刷新缓存
+	lock  bool          // 是否加锁
+	color bool          // 是否支持颜色,不支持时删除颜色
+}
+
+func newLineWriter(w io.Writer, o *ChannelOptions) *lineWriter {
+	lw := &lineWriter{w: w, line: o.LineBuffered, lock: !o.NoLock, color: ColorEnabled(w)}
+	if o.BufferSize > 0 {
+		lw.buf = bufio.NewWriterSize(w, o.BufferSize)
+		lw.lock = true
+	}
+	return lw
+}
+
+// WriteLine 写入一行,没有换行符时自动添加,不缓存时只调用一次Write,保证整行输出
+func (w *lineWriter) WriteLine(text []byte) error {
+	var b *Buffer
+	if !w.color && bytes.IndexByte(text, 0x1b) != -1 {
+		b = NewBuffer()
+		defer b.Free()
+		text = stripColor(b, text)
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	if text[len(text)-1] != '\n' {
+		if b == nil {
+			b = NewBuffer()
+			defer b.Free()
+			b.AppendBytes(text)
+		}
+		b.AppendByte('\n')
+		text = b.Bytes()
+	}
+
+	if w.lock {
+		w.mux.Lock()
+		defer w.mux.Unlock()
+	}
+	if w.buf == nil {
+		_, err := w.w.Write(text)
+		return err
+	}
+
+	if _, err := w.buf.Write(text); err != nil {
+		return err
+	}
+	if w.line {
+		return w.buf.Flush()
+	}
+	return nil
+}
+
+// Flush 写入缓存中的数据
+func (w *lineWriter) Flush() error {
+	if w.buf == nil {
+		return nil
+	}
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.buf.Flush()
+}
+
+// isTerminal 判断Writer是否为终端,只支持带有文件描述符的Writer,比如*os.File
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(interface{ Fd() uintptr })
+	return ok && runtime.GOOS != "windows" && IsTerminal(int(f.Fd()))
+}
@@ -0,0 +1,206 @@
+package glog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const colorReset = "\x1b[0m"
+
+var colorNames = map[string]int{
+	"black":   0,
+	"red":     1,
+	"green":   2,
+	"yellow":  3,
+	"blue":    4,
+	"magenta": 5,
+	"cyan":    6,
+	"white":   7,
+}
+
+var styleAttrs = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"italic":    "3",
+	"underline": "4",
+	"blink":     "5",
+	"reverse":   "7",
+}
+
+// Style 终端样式,由ANSI SGR参数组成,为空时不着色
+type Style struct {
+	start string // \x1b[...m
+}
+
+// ParseStyle 解析样式,多个属性以空格分隔,比如"bold red","bg:blue white","#ff8800","bg:196"
+// 颜色支持名字(black,red,green,yellow,blue,magenta,cyan,white),bright前缀表示高亮色,
+// 0-255表示256色,#rrggbb表示真彩色,bg:前缀表示背景色
+// 属性支持bold,dim,italic,underline,blink,reverse
+func ParseStyle(spec string) (Style, error) {
+	var codes []string
+	for _, token := range strings.Fields(strings.ToLower(spec)) {
+		if attr, ok := styleAttrs[token]; ok {
+			codes = append(codes, attr)
+			continue
+		}
+
+		bg := false
+		if strings.HasPrefix(token, "bg:") {
+			bg = true
+			token = token[3:]
+		}
+		code, err := parseColor(token, bg)
+		if err != nil {
+			return Style{}, err
+		}
+		codes = append(codes, code)
+	}
+
+	if len(codes) == 0 {
+		return Style{}, nil
+	}
+	return Style{start: "\x1b[" + strings.Join(codes, ";") + "m"}, nil
+}
+
+// MustParseStyle 解析样式,失败则抛出异常
+func MustParseStyle(spec string) Style {
+	s, err := ParseStyle(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseColor 转换为SGR参数
+func parseColor(token string, bg bool) (string, error) {
+	base := 30
+	if bg {
+		base = 40
+	}
+
+	if strings.HasPrefix(token, "#") {
+		v, err := strconv.ParseUint(token[1:], 16, 32)
+		if err != nil || len(token) != 7 {
+			return "", fmt.Errorf("invalid color %q", token)
+		}
+		return fmt.Sprintf("%d;2;%d;%d;%d", base+8, v>>16, (v>>8)&0xff, v&0xff), nil
+	}
+
+	if n, err := strconv.Atoi(token); err == nil {
+		if n < 0 || n > 255 {
+			return "", fmt.Errorf("invalid color %q", token)
+		}
+		return fmt.Sprintf("%d;5;%d", base+8, n), nil
+	}
+
+	bright := strings.HasPrefix(token, "bright")
+	if bright {
+		base += 60
+		token = token[len("bright"):]
+	}
+	if c, ok := colorNames[token]; ok {
+		return strconv.Itoa(base + c), nil
+	}
+
+	return "", fmt.Errorf("invalid color %q", token)
+}
+
+// IsEmpty 是否为空样式
+func (s Style) IsEmpty() bool {
+	return s.start == ""
+}
+
+// AppendStart 写入样式开始的控制序列
+func (s Style) AppendStart(b *Buffer) {
+	b.AppendString(s.start)
+}
+
+// AppendEnd 写入重置样式的控制序列
+func (s Style) AppendEnd(b *Buffer) {
+	if s.start != "" {
+		b.AppendString(colorReset)
+	}
+}
+
+// ColorScheme 每个级别的样式
+type ColorScheme [TraceLevel + 1]Style
+
+// DefaultColorScheme 默认配色
+var DefaultColorScheme = ColorScheme{
+	PanicLevel: MustParseStyle("bold white bg:red"),
+	FatalLevel: MustParseStyle("magenta"),
+	ErrorLevel: MustParseStyle("red"),
+	WarnLevel:  MustParseStyle("yellow"),
+	InfoLevel:  MustParseStyle("cyan"),
+	DebugLevel: MustParseStyle("blue"),
+	TraceLevel: MustParseStyle("blue"),
+}
+
+// ParseColorScheme 解析配色,格式为"LEVEL=style,...",比如"ERROR=bold red, WARN=yellow"
+// 未配置的级别使用DefaultColorScheme
+func ParseColorScheme(spec string) (ColorScheme, error) {
+	scheme := DefaultColorScheme
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return scheme, fmt.Errorf("invalid color scheme %q", item)
+		}
+		lv, err := ParseLevel(strings.TrimSpace(kv[0]))
+		if err != nil {
+			return scheme, err
+		}
+		if scheme[lv], err = ParseStyle(kv[1]); err != nil {
+			return scheme, err
+		}
+	}
+
+	return scheme, nil
+}
+
+// ColorEnabled 判断输出到w时是否着色
+// 设置NO_COLOR时禁用,设置FORCE_COLOR(不为0或false)时强制着色,TERM=dumb时禁用,否则检查是否为终端
+func ColorEnabled(w io.Writer) bool {
+	if !colorAllowed() {
+		return false
+	}
+	if v := os.Getenv("FORCE_COLOR"); v != "" && v != "0" && v != "false" {
+		return true
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// colorAllowed 环境变量是否禁用了颜色,见https://no-color.org
+func colorAllowed() bool {
+	return os.Getenv("NO_COLOR") == ""
+}
+
+// stripColor 删除ANSI控制序列,不含控制序列时直接返回text
+func stripColor(b *Buffer, text []byte) []byte {
+	if bytes.IndexByte(text, 0x1b) == -1 {
+		return text
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == 0x1b && i+1 < len(text) && text[i+1] == '[' {
+			j := i + 2
+			for j < len(text) && (text[j] < 0x40 || text[j] > 0x7e) {
+				j++
+			}
+			i = j
+			continue
+		}
+		b.AppendByte(text[i])
+	}
+	return b.Bytes()
+}
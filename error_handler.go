@@ -0,0 +1,95 @@
+package glog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrorHandler 处理Channel和Formatter的错误,channel为Channel名,e可能为nil(比如批量发送或者连接失败)
+// 在写日志的goroutine中同步调用,不能阻塞,也不能再调用同一个Logger写日志
+type ErrorHandler func(channel string, err error, e *Entry)
+
+// DefaultErrorHandler 默认错误处理,输出到stderr,每个Channel每10秒最多输出一次
+var DefaultErrorHandler = NewRateLimitedErrorHandler(os.Stderr, time.Second*10)
+
+// NewRateLimitedErrorHandler 创建限频的ErrorHandler,每个Channel在interval内最多输出一次
+// 期间忽略的错误在interval结束时与最后一个错误一起输出,不会等到下次出错
+func NewRateLimitedErrorHandler(w io.Writer, interval time.Duration) ErrorHandler {
+	h := &rateLimitedHandler{writer: w, interval: interval, states: make(map[string]*errorState)}
+	return h.Handle
+}
+
+type errorState struct {
+	last       time.Time   // 上次输出时间
+	suppressed int         // 忽略的错误数
+	lastErr    error       // 最后一个忽略的错误
+	timer      *time.Timer // 非nil时interval结束后输出忽略的错误
+}
+
+type rateLimitedHandler struct {
+	mux      sync.Mutex
+	writer   io.Writer
+	interval time.Duration
+	states   map[string]*errorState
+}
+
+func (h *rateLimitedHandler) Handle(channel string, err error, e *Entry) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	now := time.Now()
+	st, ok := h.states[channel]
+	if !ok {
+		st = &errorState{}
+		h.states[channel] = st
+	}
+
+	if !st.last.IsZero() && now.Sub(st.last) < h.interval {
+		st.suppressed++
+		st.lastErr = err
+		if st.timer == nil {
+			st.timer = time.AfterFunc(st.last.Add(h.interval).Sub(now), func() { h.flush(channel) })
+		}
+		return
+	}
+
+	msg := fmt.Sprintf("glog: %s channel %q: %v", now.Format(time.RFC3339), channel, err)
+	if e != nil {
+		msg += fmt.Sprintf(", entry=%q", e.Text)
+	}
+	h.output(st, now, msg)
+}
+
+// flush interval结束时输出忽略的错误数
+func (h *rateLimitedHandler) flush(channel string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	st := h.states[channel]
+	now := time.Now()
+	// 已经被新的错误输出过,属于过期的定时器
+	if now.Sub(st.last) < h.interval {
+		return
+	}
+	st.timer = nil
+	if st.suppressed == 0 {
+		return
+	}
+	h.output(st, now, fmt.Sprintf("glog: %s channel %q: %v", now.Format(time.RFC3339), channel, st.lastErr))
+}
+
+// output 输出错误并附带忽略的错误数,需要持有锁
+func (h *rateLimitedHandler) output(st *errorState, now time.Time, msg string) {
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	}
+	if st.suppressed > 0 {
+		msg += fmt.Sprintf(" (%d errors suppressed)", st.suppressed)
+	}
+	_, _ = fmt.Fprintln(h.writer, msg)
+	st.last = now
+	st.suppressed = 0
+	st.lastErr = nil
+}
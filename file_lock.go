@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package glog
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile 对文件加排他的flock,用于多进程间协调切割,返回解锁函数
+func lockFile(path string) (func(), error) {
+	f, err := openLockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = flockUnlock(f)
+		_ = f.Close()
+	}, nil
+}
+
+// flockShared 加共享的flock,多个进程可以同时写入,与切割时的排他锁互斥
+func flockShared(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_SH)
+}
+
+func flockUnlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,16 @@
+package glog
+
+import "os"
+
+// lockFile windows暂不支持flock,多进程共享文件时不能协调切割
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
+
+func flockShared(f *os.File) error {
+	return nil
+}
+
+func flockUnlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,169 @@
+package glog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	periodNone rotatePeriod = iota
+	periodSecond
+	periodMinute
+	periodHour
+	periodDay
+	periodMonth
+	periodYear
+)
+
+// rotatePeriod 按时间切割的周期,由文件名中最小的时间单位决定
+type rotatePeriod int
+
+// start 返回t所在周期的开始时间
+func (p rotatePeriod) start(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	switch p {
+	case periodYear:
+		month = time.January
+		fallthrough
+	case periodMonth:
+		day = 1
+		fallthrough
+	case periodDay:
+		hour = 0
+		fallthrough
+	case periodHour:
+		min = 0
+		fallthrough
+	case periodMinute:
+		sec = 0
+	}
+	return time.Date(year, month, day, hour, min, sec, 0, loc)
+}
+
+// next 返回下一个周期的开始时间,按照日历计算,可以正确处理夏令时
+func (p rotatePeriod) next(t time.Time, loc *time.Location) time.Time {
+	start := p.start(t, loc)
+	switch p {
+	case periodSecond:
+		return start.Add(time.Second)
+	case periodMinute:
+		return start.Add(time.Minute)
+	case periodHour:
+		return start.Add(time.Hour)
+	case periodDay:
+		return start.AddDate(0, 0, 1)
+	case periodMonth:
+		return start.AddDate(0, 1, 0)
+	case periodYear:
+		return start.AddDate(1, 0, 0)
+	}
+	return time.Time{}
+}
+
+// tokenPeriod 时间token对应的周期
+func tokenPeriod(t dfType) rotatePeriod {
+	switch t {
+	case df_s1, df_s2:
+		return periodSecond
+	case df_m1, df_m2:
+		return periodMinute
+	case df_h1, df_h2, df_H1, df_H2, df_t1, df_t2:
+		return periodHour
+	case df_d1, df_d2, df_d3, df_d4:
+		return periodDay
+	case df_M1, df_M2, df_M3, df_M4:
+		return periodMonth
+	case df_y1, df_y2, df_y3, df_y4:
+		return periodYear
+	}
+	return periodNone
+}
+
+// filePattern 含有时间的文件路径,比如logs/app-%d{yyyyMMdd-HH}.log
+type filePattern struct {
+	layout  *Layout
+	loc     *time.Location
+	period  rotatePeriod
+	history *regexp.Regexp // 匹配历史文件
+}
+
+// newFilePattern 解析文件路径,不含%d{}时返回nil,loc为nil时使用路径中指定的时区或本地时区
+func newFilePattern(path string, loc *time.Location) (*filePattern, error) {
+	if !strings.Contains(path, "%d{") {
+		return nil, nil
+	}
+	l, err := NewLayout(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filePattern{layout: l, loc: loc}
+	for _, a := range l.actions {
+		if a.Key == 0 || a.Key == '%' {
+			continue
+		}
+		if a.Key != 'd' || a.Param == "" {
+			return nil, fmt.Errorf("invalid file pattern %q, only support %%d{format}", path)
+		}
+		df := a.Data.(*DateFormat)
+		if len(df.Tokens) == 0 {
+			return nil, fmt.Errorf("invalid file pattern %q, standard time layout is not supported", path)
+		}
+		if p.loc == nil {
+			p.loc = df.Loc
+		}
+		for _, t := range df.Tokens {
+			if tp := tokenPeriod(t.Type); tp != periodNone && (p.period == periodNone || tp < p.period) {
+				p.period = tp
+			}
+		}
+	}
+	if p.loc == nil {
+		p.loc = time.Local
+	}
+	// 所有时间使用相同的时区,保证与切割周期一致
+	for _, a := range l.actions {
+		if a.Key == 'd' {
+			a.Data.(*DateFormat).Loc = p.loc
+		}
+	}
+	p.history = regexp.MustCompile(p.historyExpr())
+
+	return p, nil
+}
+
+// format 返回t对应的文件路径
+func (p *filePattern) format(t time.Time) string {
+	return string(p.layout.Format(&Entry{Time: t}))
+}
+
+// next 返回下一次切割的时间
+func (p *filePattern) next(t time.Time) time.Time {
+	return p.period.next(t, p.loc)
+}
+
+// updateSymlink 更新软链接指向当前文件,先创建临时链接再重命名,保证链接始终有效
+func updateSymlink(link, target string) error {
+	if rel, err := filepath.Rel(filepath.Dir(link), target); err == nil {
+		target = rel
+	}
+	if cur, err := os.Readlink(link); err == nil && cur == target {
+		return nil
+	}
+	tmp := link + ".tmp"
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
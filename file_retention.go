@@ -0,0 +1,358 @@
+package glog
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateHook 切割后的回调,path为切割后的文件,开启压缩时为压缩后的文件,在后台goroutine中调用
+// 使用BackupIndex时序号会随着切割变化,需要上传时建议使用BackupTimestamp
+type RotateHook func(path string)
+
+// compressExt 压缩后文件的扩展名
+func compressExt(ct CompressType) string {
+	switch ct {
+	case CompressGzip:
+		return ".gz"
+	case CompressZlib:
+		return ".zz"
+	}
+	return ""
+}
+
+// needMill 是否需要在后台处理切割后的文件
+func (f *logFile) needMill() bool {
+	return f.compress != CompressNone || f.maxAge > 0 || f.maxTotal > 0 || f.hook != nil
+}
+
+// fileMill 后台处理切割后的文件,按顺序执行
+// 查找和重命名文件时与切割互斥,压缩和删除时不加锁,不影响切割
+type fileMill struct {
+	run     sync.Mutex // 同一时刻只有一个goroutine处理
+	mux     sync.Mutex // 与切割时的重命名互斥
+	wg      sync.WaitGroup
+	pathMux sync.Mutex
+	opened  map[string]int // 正在使用的文件,处理时跳过
+}
+
+// setOpened 记录文件打开或关闭
+func (m *fileMill) setOpened(path string, opened bool) {
+	m.pathMux.Lock()
+	defer m.pathMux.Unlock()
+	if m.opened == nil {
+		m.opened = make(map[string]int)
+	}
+	if opened {
+		m.opened[path]++
+	} else if m.opened[path]--; m.opened[path] <= 0 {
+		delete(m.opened, path)
+	}
+}
+
+func (m *fileMill) isOpened(path string) bool {
+	m.pathMux.Lock()
+	defer m.pathMux.Unlock()
+	return m.opened[path] > 0
+}
+
+// wait 等待后台处理完成
+func (m *fileMill) wait() {
+	m.wg.Wait()
+}
+
+// startMill 在后台压缩并清理切割后的文件,rotated为本次切割的文件,为空时只处理已有文件
+func (f *logFile) startMill(rotated ...string) {
+	if !f.needMill() {
+		return
+	}
+	f.mill.wg.Add(1)
+	go func() {
+		defer f.mill.wg.Done()
+		f.mill.run.Lock()
+		defer f.mill.run.Unlock()
+		if f.shared {
+			// 多进程共享时,通过单独的锁文件保证同一时刻只有一个进程处理,处理时不影响写入
+			unlock, err := lockFile(f.millLockPath())
+			if err != nil {
+				f.reportError(err)
+				return
+			}
+			defer unlock()
+		}
+		f.runMill(rotated)
+	}()
+}
+
+// wait 等待后台处理完成
+func (f *logFile) wait() {
+	f.mill.wait()
+}
+
+func (f *logFile) runMill(rotated []string) {
+	ext := compressExt(f.compress)
+	if ext != "" {
+		for _, name := range f.listOldFiles() {
+			if isCompressed(name) {
+				continue
+			}
+			if err := f.compressFile(name, name+ext); err != nil {
+				f.reportError(fmt.Errorf("compress %s: %w", name, err))
+			}
+		}
+	}
+
+	if f.hook != nil {
+		for _, name := range rotated {
+			// 可能已被之前的处理压缩
+			if ext != "" && !fileExists(name) && fileExists(name+ext) {
+				name += ext
+			}
+			f.hook(name)
+		}
+	}
+
+	f.removeExpired()
+}
+
+// removeExpired 删除超过保留时间或超出总大小的文件,从最新的文件开始累计大小
+func (f *logFile) removeExpired() {
+	if f.maxAge <= 0 && f.maxTotal <= 0 {
+		return
+	}
+
+	type oldFile struct {
+		name string
+		info os.FileInfo
+	}
+	var files []oldFile
+	for _, name := range f.listOldFiles() {
+		if info, err := os.Stat(name); err == nil {
+			files = append(files, oldFile{name: name, info: info})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().After(files[j].info.ModTime())
+	})
+
+	if f.shared {
+		// 与其他进程的写入互斥,其他进程可能仍在写入旧时间周期的文件
+		unlock, err := lockFile(f.lockPath())
+		if err != nil {
+			f.reportError(err)
+			return
+		}
+		defer unlock()
+	}
+	var total int64
+	deadline := time.Now().Add(-f.maxAge)
+	for _, file := range files {
+		total += file.info.Size()
+		expired := f.maxAge > 0 && file.info.ModTime().Before(deadline)
+		if expired || (f.maxTotal > 0 && total > f.maxTotal) {
+			if err := os.Remove(file.name); err != nil && !os.IsNotExist(err) {
+				f.reportError(err)
+			}
+		}
+	}
+}
+
+// listOldFiles 查找切割后的文件,与切割互斥
+func (f *logFile) listOldFiles() []string {
+	f.mill.mux.Lock()
+	defer f.mill.mux.Unlock()
+	return f.oldFiles()
+}
+
+// oldFiles 查找切割后的文件,包括按大小切割的备份和按时间切割的历史文件
+func (f *logFile) oldFiles() []string {
+	if f.pattern == nil {
+		backups := f.listBackups()
+		names := make([]string, 0, len(backups))
+		for _, b := range backups {
+			names = append(names, b.name)
+		}
+		return names
+	}
+
+	glob := f.pattern.glob()
+	matches, _ := filepath.Glob(glob)
+	backups, _ := filepath.Glob(glob + ".*")
+	// 其他文件也可能匹配Glob,比如app-%d{yyyyMMdd}.log会匹配app-error-20060102.log,需要校验时间部分
+	// 当前周期的文件即使已被关闭,之后也会继续写入,不能作为历史文件
+	// 查找之后再检查打开的文件,之后新打开的文件不会出现在结果中
+	current := f.pattern.format(f.now())
+	var names []string
+	for _, name := range append(matches, backups...) {
+		if name == current || !f.pattern.match(name) || f.mill.isOpened(name) {
+			continue
+		}
+		if name == f.symlink || name == f.symlink+".tmp" || strings.HasSuffix(name, ".lock") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// glob 将%d{}替换为*,用于查找历史文件
+func (p *filePattern) glob() string {
+	b := strings.Builder{}
+	for _, a := range p.layout.actions {
+		b.WriteString(globEscape(a.Prefix))
+		switch a.Key {
+		case 'd':
+			b.WriteByte('*')
+		case '%':
+			b.WriteByte('%')
+		}
+	}
+	return b.String()
+}
+
+// match 检查文件是否为该路径的历史文件,时间部分必须符合格式,可以带有切割或压缩的后缀
+func (p *filePattern) match(name string) bool {
+	return p.history.MatchString(name)
+}
+
+// historyExpr 将%d{}按格式转换为正则表达式
+func (p *filePattern) historyExpr() string {
+	b := strings.Builder{}
+	b.WriteByte('^')
+	for _, a := range p.layout.actions {
+		b.WriteString(regexp.QuoteMeta(a.Prefix))
+		switch a.Key {
+		case 'd':
+			for _, t := range a.Data.(*DateFormat).Tokens {
+				b.WriteString(dfTokenExpr(t))
+			}
+		case '%':
+			b.WriteByte('%')
+		}
+	}
+	b.WriteString(`(\..+)?$`)
+	return b.String()
+}
+
+// dfTokenExpr 时间token对应的正则表达式
+func dfTokenExpr(t dfToken) string {
+	switch t.Type {
+	case df_text:
+		return regexp.QuoteMeta(t.Data)
+	case df_d1, df_h1, df_H1, df_m1, df_M1, df_s1:
+		return `\d{1,2}`
+	case df_d2, df_h2, df_H2, df_m2, df_M2, df_s2, df_y2, df_ff:
+		return `\d{2}`
+	case df_d3, df_M3:
+		return `[A-Za-z]{3}`
+	case df_d4, df_M4:
+		return `[A-Za-z]+`
+	case df_f:
+		return `\d{1,3}`
+	case df_fff, df_y3:
+		return `\d{3}`
+	case df_ffff, df_y4:
+		return `\d{4}`
+	case df_fffff:
+		return `\d{5}`
+	case df_t1:
+		return `[AP]`
+	case df_t2:
+		return `(AM|PM)`
+	case df_y1:
+		return `\d`
+	case df_z1:
+		return `(Z|[+-]\d{2})`
+	case df_z2:
+		return `[+-]\d{2}`
+	case df_z3:
+		return `[+-]\d{2}:\d{2}`
+	}
+	return regexp.QuoteMeta(t.Data)
+}
+
+func isCompressed(name string) bool {
+	switch filepath.Ext(name) {
+	case ".gz", ".zz", ".tmp":
+		return true
+	}
+	return false
+}
+
+// compressFile 压缩文件,完成后删除原文件,保留原文件的修改时间,用于按时间清理
+// 压缩时不加锁,完成后加锁检查原文件没有被切割重命名或继续写入,否则放弃,下次再处理
+func (f *logFile) compressFile(src, dst string) error {
+	tmp, info, err := compressTemp(src, dst, f.compress, f.compressLevel)
+	if err != nil {
+		return err
+	}
+
+	f.mill.mux.Lock()
+	defer f.mill.mux.Unlock()
+	if f.shared {
+		// 与其他进程的写入互斥,其他进程可能仍在写入旧时间周期的文件
+		unlock, err := lockFile(f.lockPath())
+		if err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		defer unlock()
+	}
+	if cur, err := os.Stat(src); err != nil || !os.SameFile(cur, info) || cur.Size() != info.Size() {
+		_ = os.Remove(tmp)
+		return nil
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+	return os.Remove(src)
+}
+
+// compressTemp 压缩到临时文件,返回临时文件和压缩前原文件的信息
+// 临时文件以.开头,不会被当作备份文件切割
+func compressTemp(src, dst string, ct CompressType, level int) (string, os.FileInfo, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", nil, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", nil, err
+	}
+	var w io.WriteCloser
+	if ct == CompressZlib {
+		w, err = zlib.NewWriterLevel(out, level)
+	} else {
+		w, err = gzip.NewWriterLevel(out, level)
+	}
+	if err == nil {
+		if _, err = io.Copy(w, in); err == nil {
+			err = w.Close()
+		}
+	}
+	if e := out.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", nil, err
+	}
+	return tmp, info, nil
+}
@@ -0,0 +1,536 @@
+package glog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupIndex 备份文件以序号结尾,比如app.log.1,序号越小越新,默认
+	BackupIndex BackupNaming = iota
+	// BackupTimestamp 备份文件以切割时间结尾,比如app.log.2006-01-02T15-04-05.000
+	BackupTimestamp
+)
+
+// BackupNaming 切割后备份文件的命名方式
+type BackupNaming int
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// logFile 按大小或时间切割的日志文件,非并发安全,由调用者加锁
+type logFile struct {
+	raw     string       // 配置的路径,可能含有%d{}
+	path    string       // 当前文件路径
+	shared  bool         // 多进程共享,切割时加文件锁
+	pattern *filePattern // 非nil时按时间切割
+	next    time.Time    // 下一次按时间切割的时间
+	symlink string       // 指向当前文件的软链接
+	maxSize int64        // 0表示不切割
+	backups int          // 最多保留的备份数,0表示不限制
+	naming  BackupNaming
+	file    *os.File
+	buf     *bufio.Writer // 非nil时使用缓存
+	bufSize int
+	size    int64
+	now     func() time.Time
+	onError func(err error) // 报告不影响写入的错误
+
+	compress      CompressType  // 切割后的压缩方式
+	compressLevel int           // 压缩级别
+	maxAge        time.Duration // 切割后的文件最长保留时间
+	maxTotal      int64         // 切割后的文件最多占用的字节数
+	hook          RotateHook
+	milled        bool      // 启动时是否已经处理过历史文件
+	mill          *fileMill // 后台处理,同一个Channel的文件共享
+	err           error     // 打开失败的错误,Reopen前不再重试
+	lock          *os.File  // 多进程共享时写入加共享锁
+	locked        bool
+}
+
+// newLogFile 创建日志文件,path中含有%d{}时按时间切割
+func newLogFile(path string, o *ChannelOptions) (*logFile, error) {
+	// 与Glob返回的路径保持一致,比如去掉./
+	path = filepath.Clean(path)
+	f := &logFile{
+		raw:           path,
+		path:          path,
+		shared:        o.Shared,
+		symlink:       o.Symlink,
+		maxSize:       o.MaxSize,
+		backups:       o.MaxBackups,
+		naming:        o.BackupNaming,
+		now:           time.Now,
+		compress:      o.CompressType,
+		compressLevel: o.CompressLevel,
+		maxAge:        o.MaxAge,
+		maxTotal:      o.MaxTotalSize,
+		hook:          o.RotateHook,
+		bufSize:       o.BufferSize,
+		mill:          &fileMill{},
+	}
+	pattern, err := newFilePattern(path, o.TimeZone)
+	if err != nil {
+		return f, err
+	}
+	f.pattern = pattern
+	return f, nil
+}
+
+// open 以追加的方式打开文件,重启后不会覆盖已有内容
+func (f *logFile) open() error {
+	if f.file != nil {
+		return nil
+	}
+	if f.pattern != nil {
+		now := f.now()
+		f.path = f.pattern.format(now)
+		f.next = f.pattern.next(now)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	if f.bufSize > 0 && f.buf == nil {
+		f.buf = bufio.NewWriterSize(fileWriter{f}, f.bufSize)
+	}
+	f.mill.setOpened(f.path, true)
+	if f.symlink != "" {
+		if err := updateSymlink(f.symlink, f.path); err != nil {
+			f.reportError(fmt.Errorf("symlink %s: %w", f.symlink, err))
+		}
+	}
+	// 启动时处理上次运行遗留的文件
+	if !f.milled {
+		f.milled = true
+		f.startMill()
+	}
+	return nil
+}
+
+func (f *logFile) reportError(err error) {
+	if f.onError != nil {
+		f.onError(err)
+	}
+}
+
+// fileWriter 写入当前打开的文件,重新打开后缓存的数据写入新文件
+type fileWriter struct {
+	f *logFile
+}
+
+func (w fileWriter) Write(p []byte) (int, error) {
+	return w.f.file.Write(p)
+}
+
+// close 写入缓存并关闭文件
+func (f *logFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.flush()
+	if e := f.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	f.mill.setOpened(f.path, false)
+	f.file = nil
+	f.buf = nil
+	f.size = 0
+	return err
+}
+
+// closeLatest 写入缓存并关闭文件,多进程共享时先检查文件是否已被其他进程切割,同时关闭锁文件
+func (f *logFile) closeLatest() error {
+	err := f.flushLatest()
+	if e := f.close(); e != nil && err == nil {
+		err = e
+	}
+	if f.lock != nil {
+		_ = f.lock.Close()
+		f.lock = nil
+	}
+	return err
+}
+
+// flush 将缓存写入文件
+func (f *logFile) flush() error {
+	if f.buf == nil {
+		return nil
+	}
+	err := f.buf.Flush()
+	if err != nil {
+		// bufio出错后不能继续使用,丢弃缓存的数据
+		f.buf.Reset(fileWriter{f})
+	}
+	return err
+}
+
+// flushLatest 写入缓存,多进程共享时先检查文件是否已被其他进程切割,避免写入旧文件
+func (f *logFile) flushLatest() error {
+	if !f.shared || f.buf == nil || f.buf.Buffered() == 0 {
+		return f.flush()
+	}
+	f.lockShared()
+	defer f.unlockShared()
+	if _, err := f.watch(); err != nil {
+		return err
+	}
+	return f.flush()
+}
+
+// lockShared 多进程共享时写入前加共享锁,其他进程切割,压缩完成和删除时加排他锁,保证检查文件之后写入之前不会被切割
+// 加锁失败时报告错误并继续写入
+func (f *logFile) lockShared() {
+	if f.lock == nil {
+		lock, err := openLockFile(f.lockPath())
+		if err != nil {
+			f.reportError(err)
+			return
+		}
+		f.lock = lock
+	}
+	if err := flockShared(f.lock); err != nil {
+		f.reportError(err)
+		return
+	}
+	f.locked = true
+}
+
+func (f *logFile) unlockShared() {
+	if f.locked {
+		f.locked = false
+		_ = flockUnlock(f.lock)
+	}
+}
+
+// checkShared 多进程共享时检查文件是否已被其他进程切割,并使用文件的实际大小
+func (f *logFile) checkShared() {
+	if f.file == nil {
+		return
+	}
+	if _, err := f.watch(); err != nil && f.file != nil {
+		f.reportError(err)
+	}
+}
+
+// sync 写入缓存并同步到磁盘
+func (f *logFile) sync() error {
+	if f.file == nil {
+		return nil
+	}
+	if err := f.flushLatest(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// watch 检查文件是否被外部工具或其他进程移动,删除或截断,移动或删除时重新打开,返回是否重新打开
+// 截断时(比如logrotate的copytruncate)更新文件大小,O_APPEND保证后续从文件末尾写入
+// 多进程共享时总是使用文件的实际大小,包括其他进程写入的数据
+func (f *logFile) watch() (bool, error) {
+	if f.file == nil {
+		return false, nil
+	}
+	cur, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err != nil || !os.SameFile(cur, info) {
+		return true, f.reopen()
+	}
+
+	size := cur.Size()
+	if f.buf != nil {
+		size += int64(f.buf.Buffered())
+	}
+	if size < f.size || f.shared {
+		f.size = size
+	}
+	return false, nil
+}
+
+// reopen 关闭后重新打开,缓存的数据写入新文件
+func (f *logFile) reopen() error {
+	buf := f.buf
+	f.buf = nil
+	if err := f.close(); err != nil {
+		f.reportError(err)
+	}
+	f.buf = buf
+	if err := f.open(); err != nil {
+		f.buf = nil
+		return err
+	}
+	if f.buf != nil {
+		f.size += int64(f.buf.Buffered())
+	}
+	return nil
+}
+
+// lockPath 多进程共享时使用的锁文件,去掉路径中的时间,所有时间的文件使用同一个锁
+func (f *logFile) lockPath() string {
+	path := f.raw
+	for {
+		beg := strings.Index(path, "%d{")
+		if beg == -1 {
+			break
+		}
+		end := strings.IndexByte(path[beg:], '}')
+		if end == -1 {
+			break
+		}
+		path = path[:beg] + path[beg+end+1:]
+	}
+	return path + ".lock"
+}
+
+// millLockPath 多进程共享时后台处理使用的锁文件,与写入使用的锁分开,处理时不影响写入
+func (f *logFile) millLockPath() string {
+	return strings.TrimSuffix(f.lockPath(), ".lock") + ".mill.lock"
+}
+
+// openLockFile 打开锁文件,不存在时创建
+func openLockFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// output 写入缓存或文件
+// 多进程共享时缓存不足则先写入,保证每条日志通过一次write写入,配合O_APPEND不会与其他进程交错
+func (f *logFile) output(p []byte) (int, error) {
+	if f.buf == nil {
+		return f.file.Write(p)
+	}
+	if f.shared && len(p) > f.buf.Available() {
+		if err := f.flush(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.buf.Write(p)
+	if err != nil {
+		f.buf.Reset(fileWriter{f})
+	}
+	return n, err
+}
+
+// write 写入前检查大小,超出时先切割,单条日志超过maxSize时也会完整写入
+func (f *logFile) write(p []byte) (int, error) {
+	if f.file != nil && f.pattern != nil && !f.now().Before(f.next) {
+		// 到达时间周期,关闭后按新的时间重新打开
+		// 多进程共享时加共享锁,保证缓存写入旧文件时不会被其他进程压缩或删除
+		if f.shared {
+			f.lockShared()
+			f.checkShared()
+		}
+		old := f.path
+		if err := f.close(); err != nil {
+			f.reportError(err)
+		}
+		err := f.open()
+		f.unlockShared()
+		if err != nil {
+			return 0, err
+		}
+		if f.path != old {
+			f.startMill(old)
+		}
+	}
+	if f.shared {
+		// 多进程共享时其他进程可能已经切割,先重新打开,否则会写入即将被压缩或删除的备份
+		f.lockShared()
+		defer f.unlockShared()
+		f.checkShared()
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		// 切割时加排他锁,需要先释放共享锁
+		f.unlockShared()
+		err := f.rotate()
+		if f.shared {
+			f.lockShared()
+			f.checkShared()
+		}
+		if err != nil {
+			// 切割失败时继续写入当前文件
+			if f.file == nil {
+				return 0, err
+			}
+			n, _ := f.output(p)
+			f.size += int64(n)
+			return n, fmt.Errorf("rotate %s: %w", f.path, err)
+		}
+	}
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	n, err := f.output(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate 关闭当前文件,重命名为备份文件,然后重新打开
+// 多进程共享时加文件锁,如果文件已被其他进程切割,则只重新打开
+func (f *logFile) rotate() error {
+	if err := f.flushLatest(); err != nil {
+		return err
+	}
+	var cur os.FileInfo
+	if f.file != nil {
+		cur, _ = f.file.Stat()
+	}
+	if err := f.close(); err != nil {
+		return err
+	}
+
+	f.mill.mux.Lock()
+	if f.shared {
+		unlock, err := lockFile(f.lockPath())
+		if err != nil {
+			f.mill.mux.Unlock()
+			_ = f.open()
+			return err
+		}
+		defer unlock()
+		if info, err := os.Stat(f.path); err != nil || cur == nil || !os.SameFile(cur, info) {
+			f.mill.mux.Unlock()
+			return f.open()
+		}
+	}
+
+	var name string
+	var err error
+	if f.naming == BackupTimestamp {
+		name, err = f.rotateTimestamp()
+	} else {
+		name, err = f.rotateIndex()
+	}
+	if err == nil {
+		f.removeBackups()
+	}
+	f.mill.mux.Unlock()
+	if err != nil {
+		_ = f.open()
+		return err
+	}
+
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.startMill(name)
+	return nil
+}
+
+// rotateIndex 依次将.n重命名为.n+1,当前文件重命名为.1
+func (f *logFile) rotateIndex() (string, error) {
+	backups := f.listBackups()
+	for i := len(backups) - 1; i >= 0; i-- {
+		b := backups[i]
+		if err := os.Rename(b.name, f.backupName(strconv.Itoa(b.index+1), b.ext)); err != nil {
+			return "", err
+		}
+	}
+	name := f.path + ".1"
+	return name, os.Rename(f.path, name)
+}
+
+// rotateTimestamp 当前文件重命名为切割时间,同一毫秒内多次切割时增加序号
+func (f *logFile) rotateTimestamp() (string, error) {
+	name := f.path + "." + time.Now().Format(backupTimeFormat)
+	target := name
+	for i := 1; fileExists(target); i++ {
+		target = name + "-" + strconv.Itoa(i)
+	}
+	return target, os.Rename(f.path, target)
+}
+
+// removeBackups 删除超出数量的旧备份
+func (f *logFile) removeBackups() {
+	if f.backups <= 0 {
+		return
+	}
+	backups := f.listBackups()
+	for i := f.backups; i < len(backups); i++ {
+		_ = os.Remove(backups[i].name)
+	}
+}
+
+func (f *logFile) backupName(suffix, ext string) string {
+	return f.path + "." + suffix + ext
+}
+
+// backupFile 备份文件
+type backupFile struct {
+	name  string
+	ext   string // 压缩等附加的扩展名
+	index int    // 序号命名时的序号
+	stamp string // 时间命名时的时间
+}
+
+// listBackups 查找备份文件,按照从新到旧排序
+func (f *logFile) listBackups() []backupFile {
+	matches, _ := filepath.Glob(globEscape(f.path) + ".*")
+	prefix := f.path + "."
+	backups := make([]backupFile, 0, len(matches))
+	for _, name := range matches {
+		b := backupFile{name: name}
+		suffix := strings.TrimPrefix(name, prefix)
+		if i := strings.IndexByte(suffix, '.'); i != -1 && f.naming == BackupIndex {
+			suffix, b.ext = suffix[:i], suffix[i:]
+		}
+		if f.naming == BackupIndex {
+			index, err := strconv.Atoi(suffix)
+			if err != nil || index <= 0 {
+				continue
+			}
+			b.index = index
+		} else {
+			if len(suffix) < len(backupTimeFormat) {
+				continue
+			}
+			if _, err := time.Parse(backupTimeFormat, suffix[:len(backupTimeFormat)]); err != nil {
+				continue
+			}
+			b.stamp = suffix
+		}
+		backups = append(backups, b)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if f.naming == BackupIndex {
+			return backups[i].index < backups[j].index
+		}
+		return backups[i].stamp > backups[j].stamp
+	})
+	return backups
+}
+
+// globEscape 转义Glob中的特殊字符
+func globEscape(path string) string {
+	r := strings.NewReplacer("*", "\\*", "?", "\\?", "[", "\\[")
+	return r.Replace(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,208 @@
+package glog
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxOpenFiles 拆分文件时默认最多打开的文件数
+const DefaultMaxOpenFiles = 64
+
+// LevelFile 额外输出的文件,不低于Level的日志同时写入Path,比如ErrorLevel的日志写入error.log
+type LevelFile struct {
+	Level Level
+	Path  string
+}
+
+// pathPart 文件路径的一部分
+type pathPart struct {
+	text  string // 原样输出,包括%d{}
+	key   string // %x{key},Tag或Field的值
+	level bool   // %p,日志级别
+}
+
+// pathTemplate 文件路径模板,支持按Tag,Field或级别拆分,比如logs/%x{tenant}/app-%p.log
+// 时间%d{}保留,由logFile按时间切割
+type pathTemplate struct {
+	raw     string
+	parts   []pathPart
+	dynamic bool // 是否依赖Entry
+}
+
+func parsePathTemplate(path string) (*pathTemplate, error) {
+	t := &pathTemplate{raw: path}
+	text := strings.Builder{}
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 >= len(path) {
+			text.WriteByte(path[i])
+			continue
+		}
+		switch path[i+1] {
+		case 'p':
+			t.addText(&text)
+			t.parts = append(t.parts, pathPart{level: true})
+			i++
+		case 'x':
+			end := strings.IndexByte(path[i:], '}')
+			if i+2 >= len(path) || path[i+2] != '{' || end == -1 {
+				return nil, fmt.Errorf("invalid file path %q, need %%x{key}", path)
+			}
+			key := strings.TrimSpace(path[i+3 : i+end])
+			if key == "" {
+				return nil, fmt.Errorf("invalid file path %q, empty key", path)
+			}
+			t.addText(&text)
+			t.parts = append(t.parts, pathPart{key: key})
+			i += end
+		default:
+			text.WriteByte(path[i])
+		}
+	}
+	t.addText(&text)
+	for _, p := range t.parts {
+		if p.level || p.key != "" {
+			t.dynamic = true
+		}
+	}
+	return t, nil
+}
+
+func (t *pathTemplate) addText(b *strings.Builder) {
+	if b.Len() > 0 {
+		t.parts = append(t.parts, pathPart{text: b.String()})
+		b.Reset()
+	}
+}
+
+// resolve 返回Entry对应的路径
+func (t *pathTemplate) resolve(e *Entry) string {
+	if !t.dynamic {
+		return t.raw
+	}
+	b := strings.Builder{}
+	for _, p := range t.parts {
+		switch {
+		case p.level:
+			b.WriteString(e.Level.String())
+		case p.key != "":
+			b.WriteString(pathValue(e, p.key))
+		default:
+			b.WriteString(p.text)
+		}
+	}
+	return b.String()
+}
+
+// pathValue 查找Tag或Field的值,替换路径分隔符等字符,避免写入其他目录,为空时返回default
+func pathValue(e *Entry, key string) string {
+	value, ok := e.Tags.Get(key)
+	if !ok {
+		if f := findField(e, key); f != nil {
+			b := NewBuffer()
+			f.AppendValueToBuffer(b)
+			value = b.String()
+			b.Free()
+		}
+	}
+	value = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '%', '*', '?', '[':
+			return '_'
+		}
+		if r < ' ' {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" || value == "." || value == ".." {
+		return "default"
+	}
+	return value
+}
+
+// fileSet 按路径管理打开的文件,超过数量时关闭最久未使用的文件,不依赖Entry的文件不会被关闭
+type fileSet struct {
+	opts    *ChannelOptions
+	mill    *fileMill // 所有文件共享,保证后台处理按顺序执行
+	maxOpen int
+	onError func(err error)
+	files   map[string]*list.Element
+	lru     *list.List      // 元素为*fileEntry,最近使用的在前
+	milled  map[string]bool // 被关闭的文件是否处理过历史文件,重新创建时不再处理
+}
+
+type fileEntry struct {
+	key    string
+	file   *logFile
+	pinned bool
+}
+
+func newFileSet(o *ChannelOptions, onError func(err error)) *fileSet {
+	s := &fileSet{opts: o, mill: &fileMill{}, maxOpen: o.MaxOpenFiles, onError: onError, files: make(map[string]*list.Element), lru: list.New(), milled: make(map[string]bool)}
+	if s.maxOpen <= 0 {
+		s.maxOpen = DefaultMaxOpenFiles
+	}
+	return s
+}
+
+// pin 创建不依赖Entry的文件,不会因为超过数量被关闭,symlink表示是否更新软链接
+func (s *fileSet) pin(path string, symlink bool) *logFile {
+	f := s.get(path)
+	elem := s.files[path]
+	elem.Value.(*fileEntry).pinned = true
+	if !symlink {
+		f.symlink = ""
+	}
+	return f
+}
+
+// get 返回路径对应的文件,不存在时创建,不会打开文件
+func (s *fileSet) get(path string) *logFile {
+	if elem, ok := s.files[path]; ok {
+		s.lru.MoveToFront(elem)
+		return elem.Value.(*fileEntry).file
+	}
+
+	f, err := newLogFile(path, s.opts)
+	if err != nil {
+		s.onError(fmt.Errorf("invalid file %q: %w", path, err))
+	}
+	f.mill = s.mill
+	f.onError = s.onError
+	f.symlink = ""
+	f.milled = s.milled[path]
+	s.files[path] = s.lru.PushFront(&fileEntry{key: path, file: f})
+	s.evict()
+	return f
+}
+
+// evict 超过数量时从最久未使用的文件开始关闭,跳过固定的文件
+func (s *fileSet) evict() {
+	elem := s.lru.Back()
+	for s.lru.Len() > s.maxOpen && elem != nil {
+		prev := elem.Prev()
+		if old := elem.Value.(*fileEntry); !old.pinned {
+			s.lru.Remove(elem)
+			delete(s.files, old.key)
+			if old.file.milled {
+				s.milled[old.key] = true
+			}
+			if err := old.file.closeLatest(); err != nil {
+				s.onError(err)
+			}
+		}
+		elem = prev
+	}
+}
+
+// each 遍历所有文件,返回第一个错误
+func (s *fileSet) each(fn func(f *logFile) error) error {
+	var res error
+	for elem := s.lru.Front(); elem != nil; elem = elem.Next() {
+		if err := fn(elem.Value.(*fileEntry).file); err != nil && res == nil {
+			res = err
+		}
+	}
+	return res
+}
@@ -43,15 +43,26 @@ func (f *jsonFormatter) Name() string {
 }
 
 func (f *jsonFormatter) Format(e *Entry) ([]byte, error) {
-	enc := NewJsonEncoder()
+	buf := NewBuffer()
+	_ = f.FormatTo(buf, e)
+	data := append([]byte(nil), buf.Bytes()...)
+	buf.Free()
+	return data, nil
+}
+
+func (f *jsonFormatter) FormatTo(b *Buffer, e *Entry) error {
+	enc := NewJsonEncoderTo(b)
+	tmp := NewBuffer()
 	enc.Begin()
 	for _, f := range f.fields {
 		// 都以字符串的形式输出
-		value := f.Format.Format(e)
-		if len(value) > 0 {
-			enc.AddString(f.Key, string(value))
+		tmp.Reset()
+		f.Format.FormatTo(tmp, e)
+		if !tmp.Empty() {
+			enc.AddBytesString(f.Key, tmp.Bytes())
 		}
 	}
+	tmp.Free()
 
 	if !e.Tags.Empty() {
 		size := e.Tags.Len()
@@ -61,14 +72,12 @@ func (f *jsonFormatter) Format(e *Entry) ([]byte, error) {
 		}
 	}
 
-	if len(e.Fields) > 0 {
-		for _, f := range e.Fields {
-			enc.AddField(&f, nil)
-		}
+	for i := range e.Fields {
+		enc.AddField(&e.Fields[i], nil)
 	}
 
 	enc.End()
-	return enc.Bytes(), nil
+	return nil
 }
 
 func (f *jsonFormatter) Parse(layout string) error {
@@ -0,0 +1,251 @@
+package glog
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultPrettyTimeFormat  = "HH:mm:ss.fff"
+	defaultPrettyCallerWidth = 24
+	prettyIndent             = "    "
+	prettyMinWrap            = 20 // 表头后剩余宽度小于该值时,换行只缩进prettyIndent
+)
+
+// 字段配色
+var (
+	prettyDimStyle    = MustParseStyle("dim")
+	prettyStringStyle = MustParseStyle("green")
+	prettyNumberStyle = MustParseStyle("cyan")
+	prettyBoolStyle   = MustParseStyle("yellow")
+	prettyOtherStyle  = MustParseStyle("magenta")
+	prettyErrorStyle  = MustParseStyle("red")
+)
+
+// PrettyOptions 开发环境控制台格式配置
+type PrettyOptions struct {
+	Color       bool         // 是否着色
+	Width       int          // 终端宽度,Fields超过宽度时换行,0表示不换行
+	TimeFormat  string       // 时间格式,见DateFormat,默认HH:mm:ss.fff
+	CallerWidth int          // 调用位置的列宽,默认24,超出时保留末尾
+	Scheme      *ColorScheme // 级别配色,默认DefaultColorScheme
+}
+
+// NewPrettyFormatter 创建适合开发环境阅读的Formatter,根据w判断是否着色及终端宽度
+// w不是终端时使用默认的文本格式,比如: NewConsoleChannel(WithFormatter(NewPrettyFormatter(os.Stdout)))
+func NewPrettyFormatter(w io.Writer) Formatter {
+	if !isTerminal(w) {
+		return MustNewTextFormatter(defaultTextLayout)
+	}
+
+	f, _ := NewPrettyFormatterWithOptions(PrettyOptions{Color: ColorEnabled(w), Width: terminalWidth(w)})
+	return f
+}
+
+// NewPrettyFormatterWithOptions 通过配置创建Formatter
+// 每行以时间,级别,调用位置对齐的表头开始,多行日志和换行的Fields与表头后对齐,error和多行的值在最后单独输出
+func NewPrettyFormatterWithOptions(o PrettyOptions) (Formatter, error) {
+	if o.TimeFormat == "" {
+		o.TimeFormat = defaultPrettyTimeFormat
+	}
+	if o.CallerWidth <= 0 {
+		o.CallerWidth = defaultPrettyCallerWidth
+	}
+	df, err := NewDateFormat(o.TimeFormat)
+	if err != nil {
+		return nil, err
+	}
+	f := &prettyFormatter{opts: o, date: df, scheme: DefaultColorScheme}
+	if o.Scheme != nil {
+		f.scheme = *o.Scheme
+	}
+	return f, nil
+}
+
+// terminalWidth 终端宽度,优先使用COLUMNS环境变量
+func terminalWidth(w io.Writer) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if f, ok := w.(interface{ Fd() uintptr }); ok {
+		return TerminalWidth(int(f.Fd()))
+	}
+	return 0
+}
+
+type prettyFormatter struct {
+	opts   PrettyOptions
+	date   *DateFormat
+	scheme ColorScheme
+}
+
+func (f *prettyFormatter) Name() string {
+	return "pretty"
+}
+
+func (f *prettyFormatter) Format(e *Entry) ([]byte, error) {
+	buf := NewBuffer()
+	_ = f.FormatTo(buf, e)
+	data := append([]byte(nil), buf.Bytes()...)
+	buf.Free()
+	return data, nil
+}
+
+func (f *prettyFormatter) FormatTo(b *Buffer, e *Entry) error {
+	w := prettyWriter{b: b, color: f.opts.Color}
+	tmp := NewBuffer()
+	defer tmp.Free()
+
+	// 表头: 时间 级别 调用位置
+	f.date.AppendFormat(tmp, e.Time)
+	w.text(prettyDimStyle, string(tmp.Bytes()))
+	w.space()
+	w.pad(f.scheme[e.Level], e.Level.String(), 5)
+	w.space()
+	if e.File != "" {
+		caller := e.File + ":" + strconv.Itoa(e.Line)
+		if n := len(caller) - f.opts.CallerWidth; n > 0 {
+			caller = caller[n:]
+		}
+		w.pad(prettyDimStyle, caller, f.opts.CallerWidth)
+		w.space()
+	}
+	header := w.col
+
+	// 多行日志与第一行对齐
+	for i, line := range strings.Split(strings.TrimRight(e.Text, "\n"), "\n") {
+		if i > 0 {
+			w.newline(header)
+		}
+		w.text(Style{}, line)
+	}
+
+	wrap := header
+	if f.opts.Width > 0 && f.opts.Width-header < prettyMinWrap {
+		wrap = len(prettyIndent)
+	}
+
+	for i := 0; i < e.Tags.Len(); i++ {
+		key, value := e.Tags.GetAt(i)
+		f.writeField(&w, key, prettyStringStyle, value, header, wrap)
+	}
+
+	// 错误和多行的值在最后以块的形式输出
+	var blocks []int
+	for i := range e.Fields {
+		field := &e.Fields[i]
+		tmp.Reset()
+		field.AppendValueToBuffer(tmp)
+		style, isErr := fieldStyle(field)
+		if isErr || strings.IndexByte(string(tmp.Bytes()), '\n') != -1 {
+			blocks = append(blocks, i)
+			continue
+		}
+		f.writeField(&w, field.Key, style, string(tmp.Bytes()), header, wrap)
+	}
+
+	for _, i := range blocks {
+		field := &e.Fields[i]
+		tmp.Reset()
+		field.AppendValueToBuffer(tmp)
+		style, _ := fieldStyle(field)
+		w.newline(0)
+		w.text(Style{}, prettyIndent)
+		w.text(prettyDimStyle, field.Key+":")
+		lines := strings.Split(strings.TrimRight(string(tmp.Bytes()), "\n"), "\n")
+		if len(lines) == 1 {
+			w.space()
+			w.text(style, lines[0])
+			continue
+		}
+		for _, line := range lines {
+			w.newline(0)
+			w.text(Style{}, prettyIndent+prettyIndent)
+			w.text(style, line)
+		}
+	}
+
+	b.AppendByte('\n')
+	return nil
+}
+
+// writeField 输出key=value,超过宽度时换行
+func (f *prettyFormatter) writeField(w *prettyWriter, key string, style Style, value string, header, wrap int) {
+	size := utf8.RuneCountInString(key) + 1 + utf8.RuneCountInString(value)
+	if w.col > header {
+		if f.opts.Width > 0 && w.col+1+size > f.opts.Width {
+			w.newline(wrap)
+		} else {
+			w.space()
+		}
+	}
+	w.text(prettyDimStyle, key+"=")
+	w.text(style, value)
+}
+
+// fieldStyle 根据类型选择配色,同时返回是否为error
+func fieldStyle(f *Field) (Style, bool) {
+	switch f.Type {
+	case FieldTypeString, FieldTypeByte:
+		return prettyStringStyle, false
+	case FieldTypeBool:
+		return prettyBoolStyle, false
+	case FieldTypeInt, FieldTypeUint, FieldTypeFloat32, FieldTypeFloat64:
+		return prettyNumberStyle, false
+	}
+
+	switch f.Value.(type) {
+	case error:
+		return prettyErrorStyle, true
+	case string:
+		return prettyStringStyle, false
+	case bool:
+		return prettyBoolStyle, false
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return prettyNumberStyle, false
+	default:
+		return prettyOtherStyle, false
+	}
+}
+
+// prettyWriter 写入Buffer并记录当前行可见的列数
+type prettyWriter struct {
+	b     *Buffer
+	color bool
+	col   int
+}
+
+func (w *prettyWriter) text(style Style, s string) {
+	if w.color {
+		style.AppendStart(w.b)
+	}
+	w.b.AppendString(s)
+	if w.color {
+		style.AppendEnd(w.b)
+	}
+	w.col += utf8.RuneCountInString(s)
+}
+
+// pad 输出并用空格补齐到width,空格不着色
+func (w *prettyWriter) pad(style Style, s string, width int) {
+	w.text(style, s)
+	for n := utf8.RuneCountInString(s); n < width; n++ {
+		w.space()
+	}
+}
+
+func (w *prettyWriter) space() {
+	w.b.AppendByte(' ')
+	w.col++
+}
+
+func (w *prettyWriter) newline(indent int) {
+	w.b.AppendByte('\n')
+	w.col = 0
+	for i := 0; i < indent; i++ {
+		w.space()
+	}
+}
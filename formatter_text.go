@@ -34,3 +34,8 @@ func (f *textFormatter) Format(e *Entry) ([]byte, error) {
 	data := f.layout.Format(e)
 	return data, nil
 }
+
+func (f *textFormatter) FormatTo(b *Buffer, e *Entry) error {
+	f.layout.FormatTo(b, e)
+	return nil
+}
@@ -9,11 +9,16 @@ import (
 // For JSON-escaping; see jsonEncoder.safeAddString below.
 const _hex = "0123456789abcdef"
 
-// NewJsonEncoder 创建json编码器
+// NewJsonEncoder 创建json编码器,使用完可调用Free回收
 func NewJsonEncoder() JsonEncoder {
 	return JsonEncoder{buf: NewBuffer(), spaced: false}
 }
 
+// NewJsonEncoderTo 创建json编码器,直接写入buf
+func NewJsonEncoderTo(buf *Buffer) JsonEncoder {
+	return JsonEncoder{buf: buf, spaced: false}
+}
+
 // JsonEncoder 简单的Json编码器,通常只需要一级
 // TODO:支持多级
 type JsonEncoder struct {
@@ -25,6 +30,12 @@ func (enc *JsonEncoder) Bytes() []byte {
 	return enc.buf.Bytes()
 }
 
+// Free 回收Buffer,之后不能再使用Bytes返回的数据
+func (enc *JsonEncoder) Free() {
+	enc.buf.Free()
+	enc.buf = nil
+}
+
 func (enc *JsonEncoder) AddField(f *Field, fn func(string) string) {
 	key := f.Key
 	if fn != nil {
@@ -90,6 +101,49 @@ func (enc *JsonEncoder) End() {
 	enc.buf.AppendByte('}')
 }
 
+// AddKey 添加key,之后需要添加Object或者Array
+func (enc *JsonEncoder) AddKey(key string) {
+	enc.addKey(key)
+}
+
+// BeginObject 开始嵌套的Object,需要以End结束
+func (enc *JsonEncoder) BeginObject() {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('{')
+}
+
+// BeginArray 开始Array
+func (enc *JsonEncoder) BeginArray() {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('[')
+}
+
+func (enc *JsonEncoder) EndArray() {
+	enc.buf.AppendByte(']')
+}
+
+// AppendString 向Array中添加字符串
+func (enc *JsonEncoder) AppendString(val string) {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('"')
+	enc.safeAddString(val)
+	enc.buf.AppendByte('"')
+}
+
+// AppendBytesString 向Array中添加字符串
+func (enc *JsonEncoder) AppendBytesString(val []byte) {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('"')
+	enc.safeAddBytes(val)
+	enc.buf.AppendByte('"')
+}
+
+// AppendRaw 直接添加已经编码好的json
+func (enc *JsonEncoder) AppendRaw(data []byte) {
+	enc.addElementSeparator()
+	enc.buf.AppendBytes(data)
+}
+
 // AddValidString 添加非空字符串
 func (enc *JsonEncoder) AddValidString(key string, val string) {
 	if len(val) > 0 {
@@ -104,6 +158,14 @@ func (enc *JsonEncoder) AddString(key string, val string) {
 	enc.buf.AppendByte('"')
 }
 
+// AddBytesString 以字符串形式添加,避免[]byte到string的转换
+func (enc *JsonEncoder) AddBytesString(key string, val []byte) {
+	enc.addKey(key)
+	enc.buf.AppendByte('"')
+	enc.safeAddBytes(val)
+	enc.buf.AppendByte('"')
+}
+
 func (enc *JsonEncoder) AddBool(key string, val bool) {
 	enc.addKey(key)
 	enc.buf.AppendBool(val)
@@ -182,6 +244,23 @@ func (enc *JsonEncoder) safeAddString(s string) {
 	}
 }
 
+// safeAddBytes is no-alloc equivalent of safeAddString(string(s)) for s []byte.
+func (enc *JsonEncoder) safeAddBytes(s []byte) {
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if enc.tryAddRuneError(r, size) {
+			i++
+			continue
+		}
+		enc.buf.AppendBytes(s[i : i+size])
+		i += size
+	}
+}
+
 // tryAddRuneSelf appends b if it is valid UTF-8 character represented in a single byte.
 func (enc *JsonEncoder) tryAddRuneSelf(b byte) bool {
 	if b >= utf8.RuneSelf {
@@ -6,8 +6,10 @@ import (
 )
 
 const (
-	actionFields = 'w'
-	actionTags   = 'x'
+	actionFields    = 'w'
+	actionTags      = 'x'
+	actionHighlight = 1 // %highlight{pattern}{scheme}
+	actionColor     = 2 // %color{style}{pattern}
 )
 
 // NewLayout 创建Layout
@@ -22,10 +24,20 @@ func NewLayout(format string) (*Layout, error) {
 
 // https://wiki.jikexueyuan.com/project/log4j/log4j-patternlayout.html
 // 实现类似log4j的PatternLayout格式,比如 %r [%t] %p %c %x - %m%n
-// TODO: 支持配色
 // https://blog.csdn.net/qq_40147863/article/details/88880053
+// 支持局部配色,设置NO_COLOR环境变量时不输出颜色:
+// %highlight{pattern}按照级别着色,可以指定配色,比如%highlight{%p}{ERROR=bold red,WARN=yellow}
+// %color{style}{pattern}使用固定样式,比如%color{dim}{%l},样式见ParseStyle
 type Layout struct {
 	actions []*Action
+	color   bool // 是否输出颜色
+}
+
+// colorBlock 着色的子模板
+type colorBlock struct {
+	layout *Layout
+	scheme ColorScheme // highlight时使用
+	style  Style       // color时使用
 }
 
 // Action {$prefix}%x{-$min.$max}token{$param}
@@ -39,6 +51,7 @@ type Action struct {
 }
 
 func (l *Layout) Parse(format string) error {
+	l.color = colorAllowed()
 	actions := make([]*Action, 0, 8)
 	lex := lexer{}
 	lex.init(format)
@@ -56,6 +69,13 @@ func (l *Layout) Parse(format string) error {
 		if lex.readExpect('.') {
 			act.Max = lex.readNumber()
 		}
+		if name := lex.readName("highlight", "color"); name != "" {
+			if err := l.parseColor(act, name, &lex); err != nil {
+				return err
+			}
+			actions = append(actions, act)
+			continue
+		}
 		act.Key = lex.readKey()
 		if lex.readExpect('{') {
 			if p, err := lex.readCloseTerm('}'); err != nil {
@@ -84,13 +104,63 @@ func (l *Layout) Parse(format string) error {
 	return nil
 }
 
+// parseColor 解析%highlight{pattern}{scheme}和%color{style}{pattern}
+func (l *Layout) parseColor(act *Action, name string, lex *lexer) error {
+	first, err := lex.readBlock()
+	if err != nil {
+		return err
+	}
+	block := &colorBlock{}
+	pattern := first
+	if name == "highlight" {
+		act.Key = actionHighlight
+		block.scheme = DefaultColorScheme
+		if lex.readExpect('{') {
+			spec, err := lex.readBlock()
+			if err != nil {
+				return err
+			}
+			if block.scheme, err = ParseColorScheme(spec); err != nil {
+				return err
+			}
+		}
+	} else {
+		act.Key = actionColor
+		if block.style, err = ParseStyle(first); err != nil {
+			return err
+		}
+		if !lex.readExpect('{') {
+			return fmt.Errorf("color need pattern, offset=%+v", lex.cur)
+		}
+		if pattern, err = lex.readBlock(); err != nil {
+			return err
+		}
+	}
+
+	if block.layout, err = NewLayout(pattern); err != nil {
+		return err
+	}
+	act.Param = pattern
+	act.Data = block
+	return nil
+}
+
 func (l *Layout) Format(e *Entry) []byte {
 	buf := NewBuffer()
+	l.FormatTo(buf, e)
+	data := append([]byte(nil), buf.Bytes()...)
+	buf.Free()
+	return data
+}
+
+// FormatTo 格式化并写入Buffer
+func (l *Layout) FormatTo(buf *Buffer, e *Entry) {
 	for _, a := range l.actions {
 		if a.Prefix != "" {
 			buf.AppendString(a.Prefix)
 		}
 
+		noWidth := a.Min == 0 && a.Max == 0
 		switch a.Key {
 		case '%':
 			buf.AppendByte('%')
@@ -103,14 +173,31 @@ func (l *Layout) Format(e *Entry) []byte {
 		case 'F':
 			buf.Put(a.Min, a.Max, e.File)
 		case 'L':
-			buf.Put(a.Min, a.Max, toString(e.Line))
+			if noWidth {
+				buf.AppendInt(int64(e.Line))
+			} else {
+				buf.Put(a.Min, a.Max, toString(e.Line))
+			}
 		case 'M':
 			buf.Put(a.Min, a.Max, e.Method)
 		case 'l':
-			buf.Putf(a.Min, a.Max, "%s(%s:%d)", e.Method, e.File, e.Line)
+			if noWidth {
+				buf.AppendString(e.Method)
+				buf.AppendByte('(')
+				buf.AppendString(e.File)
+				buf.AppendByte(':')
+				buf.AppendInt(int64(e.Line))
+				buf.AppendByte(')')
+			} else {
+				buf.Putf(a.Min, a.Max, "%s(%s:%d)", e.Method, e.File, e.Line)
+			}
 		case 'd':
-			date := a.Data.(*DateFormat).Format(e.Time)
-			buf.Put(a.Min, a.Max, date)
+			df := a.Data.(*DateFormat)
+			if noWidth {
+				df.AppendFormat(buf, e.Time)
+			} else {
+				buf.Put(a.Min, a.Max, df.Format(e.Time))
+			}
 		case 'x':
 			if a.Param == "*" {
 				for i := 0; i < e.Tags.Len(); i++ {
@@ -126,22 +213,34 @@ func (l *Layout) Format(e *Entry) []byte {
 					buf.AppendString(value)
 				}
 			}
+		case actionHighlight, actionColor:
+			block := a.Data.(*colorBlock)
+			style := block.style
+			if a.Key == actionHighlight {
+				style = block.scheme[e.Level]
+			}
+			if l.color {
+				style.AppendStart(buf)
+			}
+			block.layout.FormatTo(buf, e)
+			if l.color {
+				style.AppendEnd(buf)
+			}
 		case 'w':
 			// TODO:通过参数控制分隔符
 			if len(e.Fields) > 0 {
-				for i, v := range e.Fields {
+				for i := range e.Fields {
 					if i > 0 {
 						buf.AppendByte(' ')
 					}
-					buf.AppendString(v.Key)
+					f := &e.Fields[i]
+					buf.AppendString(f.Key)
 					buf.AppendByte('=')
-					v.AppendValueToBuffer(buf)
+					f.AppendValueToBuffer(buf)
 				}
 			}
 		}
 	}
-
-	return buf.Bytes()
 }
 
 type lexer struct {
@@ -228,6 +327,37 @@ func (l *lexer) readKey() byte {
 	return 0
 }
 
+// readName 读取%后的名字,名字后必须是{,不匹配时不移动
+func (l *lexer) readName(names ...string) string {
+	for _, name := range names {
+		if strings.HasPrefix(l.format[l.cur:], name+"{") {
+			l.cur += len(name) + 1
+			return name
+		}
+	}
+	return ""
+}
+
+// readBlock 读取到匹配的},支持嵌套,{已经读取,内容不去除空格
+func (l *lexer) readBlock() (string, error) {
+	depth := 1
+	for cur := l.cur; cur < l.end; cur++ {
+		switch l.format[cur] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				res := l.format[l.cur:cur]
+				l.cur = cur + 1
+				return res, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("not find close term, offset=%+v", l.cur)
+}
+
 // readCloseTerm 读取到结束符
 func (l *lexer) readCloseTerm(ch byte) (string, error) {
 	for cur := l.cur; cur < l.end; cur++ {
@@ -1,7 +1,6 @@
 package glog
 
 import (
-	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
@@ -187,88 +186,102 @@ func (d *DateFormat) parseToken(layout string) {
 }
 
 func (d *DateFormat) Format(t time.Time) string {
+	b := NewBuffer()
+	d.AppendFormat(b, t)
+	res := b.String()
+	b.Free()
+	return res
+}
+
+// AppendFormat 格式化时间并写入Buffer
+func (d *DateFormat) AppendFormat(b *Buffer, t time.Time) {
 	if d.Loc == nil {
 		t = t.Local()
 	} else {
 		t = t.In(d.Loc)
 	}
 
-	b := bytes.Buffer{}
-	b.Grow(32)
+	if len(d.Tokens) == 0 && d.StdLayout != "" {
+		b.AppendTime(t, d.StdLayout)
+		return
+	}
 
 	for _, token := range d.Tokens {
 		switch token.Type {
 		case df_text:
-			b.WriteString(token.Data)
+			b.AppendString(token.Data)
 		case df_d1:
-			dfWrite(&b, "%d", t.Day())
+			dfWrite(b, t.Day(), 0)
 		case df_d2:
-			dfWrite(&b, "%02d", t.Day())
+			dfWrite(b, t.Day(), 2)
 		case df_d3:
-			dfWrite(&b, "%s", t.Weekday().String()[:3])
+			b.AppendString(t.Weekday().String()[:3])
 		case df_d4:
-			dfWrite(&b, "%s", t.Weekday().String())
+			b.AppendString(t.Weekday().String())
 		case df_h1:
-			dfWrite(&b, "%d", dfToHour(t.Hour()))
+			dfWrite(b, dfToHour(t.Hour()), 0)
 		case df_h2:
-			dfWrite(&b, "%02d", dfToHour(t.Hour()))
+			dfWrite(b, dfToHour(t.Hour()), 2)
 		case df_H1:
-			dfWrite(&b, "%d", t.Hour())
+			dfWrite(b, t.Hour(), 0)
 		case df_H2:
-			dfWrite(&b, "%02d", t.Hour())
+			dfWrite(b, t.Hour(), 2)
 		case df_m1:
-			dfWrite(&b, "%d", t.Minute())
+			dfWrite(b, t.Minute(), 0)
 		case df_m2:
-			dfWrite(&b, "%02d", t.Minute())
+			dfWrite(b, t.Minute(), 2)
 		case df_M1:
-			dfWrite(&b, "%d", t.Month())
+			dfWrite(b, int(t.Month()), 0)
 		case df_M2:
-			dfWrite(&b, "%02d", t.Month())
+			dfWrite(b, int(t.Month()), 2)
 		case df_M3:
-			dfWrite(&b, "%s", t.Month().String()[:3])
+			b.AppendString(t.Month().String()[:3])
 		case df_M4:
-			dfWrite(&b, "%s", t.Month().String())
+			b.AppendString(t.Month().String())
 		case df_s1:
-			dfWrite(&b, "%d", t.Second())
+			dfWrite(b, t.Second(), 0)
 		case df_s2:
-			dfWrite(&b, "%02d", t.Second())
+			dfWrite(b, t.Second(), 2)
 		case df_f:
-			dfWrite(&b, "%d", t.Nanosecond()/1e6)
+			dfWrite(b, t.Nanosecond()/1e6, 0)
 		case df_ff:
-			dfWrite(&b, "%02d", t.Nanosecond()/1e6)
+			dfWrite(b, t.Nanosecond()/1e6, 2)
 		case df_fff:
-			dfWrite(&b, "%03d", t.Nanosecond()/1e6)
+			dfWrite(b, t.Nanosecond()/1e6, 3)
 		case df_ffff:
-			dfWrite(&b, "%04d", t.Nanosecond()/1e6)
+			dfWrite(b, t.Nanosecond()/1e6, 4)
 		case df_fffff:
-			dfWrite(&b, "%05d", t.Nanosecond()/1e6)
+			dfWrite(b, t.Nanosecond()/1e6, 5)
 		case df_t1:
-			dfWrite(&b, "%s", dfToAMPM(t.Hour())[0])
+			b.AppendByte(dfToAMPM(t.Hour())[0])
 		case df_t2:
-			dfWrite(&b, "%s", dfToAMPM(t.Hour()))
+			b.AppendString(dfToAMPM(t.Hour()))
 		case df_y1:
-			dfWrite(&b, "%d", t.Year()%10)
+			dfWrite(b, t.Year()%10, 0)
 		case df_y2:
-			dfWrite(&b, "%02d", t.Year()%100)
+			dfWrite(b, t.Year()%100, 2)
 		case df_y3:
-			dfWrite(&b, "%03d", t.Year()%1000)
+			dfWrite(b, t.Year()%1000, 3)
 		case df_y4:
-			dfWrite(&b, "%04d", t.Year())
+			dfWrite(b, t.Year(), 4)
 		case df_z1:
-			dfWrite(&b, "%s", t.Format("Z07"))
+			b.AppendTime(t, "Z07")
 		case df_z2:
-			dfWrite(&b, "%s", t.Format("-07"))
+			b.AppendTime(t, "-07")
 		case df_z3:
-			dfWrite(&b, "%s", t.Format("-07:00"))
+			b.AppendTime(t, "-07:00")
 		}
 	}
-
-	return b.String()
 }
 
-func dfWrite(b *bytes.Buffer, format string, value interface{}) {
-	text := fmt.Sprintf(format, value)
-	b.WriteString(text)
+// dfWrite 写入整数,不足width位时补0
+func dfWrite(b *Buffer, value int, width int) {
+	for w, v := 1, value/10; w < width; w, v = w+1, v/10 {
+		if v == 0 {
+			b.AppendByte('0')
+		}
+	}
+	b.AppendInt(int64(value))
 }
 
 func dfToHour(h int) int {
@@ -1,5 +1,10 @@
 package glog
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	PanicLevel Level = iota
 	FatalLevel
@@ -38,6 +43,20 @@ var syslogLevelMapping = []SyslogLevel{
 	TraceLevel: SLDebug,
 }
 
+// ParseLevel 通过名字解析日志级别,不区分大小写
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToUpper(name)
+	if name == "WARNING" {
+		return WarnLevel, nil
+	}
+	for lv, n := range levelNameMapping {
+		if n == name {
+			return Level(lv), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid level %q", name)
+}
+
 func (l Level) ToSyslogLevel() SyslogLevel {
 	return syslogLevelMapping[l]
 }
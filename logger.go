@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,28 +15,29 @@ var (
 )
 
 const (
-	DefaultMax       = 10000 // 默认日志最大条数
-	DefaultCallDepth = 3     // 堆栈深度,忽略log相关的堆栈
+	DefaultMax         = 10000           // 默认日志最大条数
+	DefaultCallDepth   = 3               // 堆栈深度,忽略log相关的堆栈
+	DefaultStopTimeout = time.Second * 5 // Stop时等待异步队列写完的最长时间
 )
 
 // Entry 一条输出日志
 type Entry struct {
 	sync.RWMutex
-	Logger    Logger               // 日志Owner
-	Level     Level                // 日志级别
-	Text      string               // 日志信息
-	Tags      SortedMap            // Tags,初始化时设置的标签信息,比如env,host等
-	Fields    []Field              // 附加字段,无序,k=v格式整体输出
-	Time      time.Time            // 时间戳
-	Context   context.Context      // 上下文,通常用于填充Fields
-	Host      string               // 配置host
-	Path      string               // 文件全路径,包含文件名
-	File      string               // 文件名
-	Line      int                  // 行号
-	Method    string               // 方法名
-	CallDepth int                  // 需要忽略的堆栈
-	outputs   map[Formatter][]byte // 相同的Formater只会构建一次
-	refs      int32                // 引用计数,当为0时,会放到缓存中
+	Logger    Logger          // 日志Owner
+	Level     Level           // 日志级别
+	Text      string          // 日志信息
+	Tags      SortedMap       // Tags,初始化时设置的标签信息,比如env,host等
+	Fields    []Field         // 附加字段,无序,k=v格式整体输出
+	Time      time.Time       // 时间戳
+	Context   context.Context // 上下文,通常用于填充Fields
+	Host      string          // 配置host
+	Path      string          // 文件全路径,包含文件名
+	File      string          // 文件名
+	Line      int             // 行号
+	Method    string          // 方法名
+	CallDepth int             // 需要忽略的堆栈
+	outputs   entryOutputs    // 相同的Formater只会构建一次
+	refs      int32           // 引用计数,当为0时,会放到缓存中
 }
 
 var gEntryPool = sync.Pool{
@@ -44,13 +46,12 @@ var gEntryPool = sync.Pool{
 	},
 }
 
-// NewEntry 创建Entry
+// NewEntry 创建Entry,使用完需要调用Free
 func NewEntry(logger Logger) *Entry {
 	e := gEntryPool.Get().(*Entry)
 	e.Logger = logger
 	e.Time = time.Now()
 	e.CallDepth = DefaultCallDepth
-	e.outputs = make(map[Formatter][]byte)
 	e.refs = 1
 	return e
 }
@@ -60,13 +61,119 @@ func (e *Entry) Obtain() {
 	atomic.AddInt32(&e.refs, 1)
 }
 
-// Free 当引用计数为0
+// Free 减少引用计数,当引用计数为0时,重置并放回缓存
 func (e *Entry) Free() {
-	if atomic.AddInt32(&e.refs, -1) <= 0 {
+	if atomic.AddInt32(&e.refs, -1) == 0 {
+		e.reset()
 		gEntryPool.Put(e)
 	}
 }
 
+// clone 复制Entry,Tags和Fields可以单独修改,格式化缓存不复制
+func (e *Entry) clone() *Entry {
+	c := NewEntry(e.Logger)
+	c.Level = e.Level
+	c.Text = e.Text
+	c.Tags.items = append([]KV(nil), e.Tags.items...)
+	c.Fields = append([]Field(nil), e.Fields...)
+	c.Time = e.Time
+	c.Context = e.Context
+	c.Host = e.Host
+	c.Path = e.Path
+	c.File = e.File
+	c.Line = e.Line
+	c.Method = e.Method
+	c.CallDepth = e.CallDepth
+	return c
+}
+
+// reset 清空所有数据,避免复用时泄露到下一条日志
+func (e *Entry) reset() {
+	e.outputs.reset()
+	e.Logger = nil
+	e.Level = 0
+	e.Text = ""
+	e.Tags = SortedMap{}
+	e.Fields = nil
+	e.Time = time.Time{}
+	e.Context = nil
+	e.Host = ""
+	e.Path = ""
+	e.File = ""
+	e.Line = 0
+	e.Method = ""
+	e.CallDepth = 0
+}
+
+// format 使用Formatter格式化,结果会缓存在Entry中,Entry释放时回收
+func (e *Entry) format(f Formatter) ([]byte, error) {
+	e.Lock()
+	defer e.Unlock()
+	if data, ok := e.outputs.get(f); ok {
+		return data, nil
+	}
+
+	if bf, ok := f.(BufferFormatter); ok {
+		buf := NewBuffer()
+		if err := bf.FormatTo(buf, e); err != nil {
+			buf.Free()
+			return nil, err
+		}
+		e.outputs.add(f, buf, buf.Bytes())
+		return buf.Bytes(), nil
+	}
+
+	data, err := f.Format(e)
+	if err != nil {
+		return nil, err
+	}
+	e.outputs.add(f, nil, data)
+	return data, nil
+}
+
+// maxEntryOutputs 每条日志最多缓存的格式化结果,通常一个Logger中Formatter的种类很少
+const maxEntryOutputs = 4
+
+type entryOutput struct {
+	formatter Formatter
+	buf       *Buffer // 非nil时,释放Entry时回收
+	data      []byte
+}
+
+// entryOutputs 固定槽位的格式化缓存,避免每条日志创建map
+// 超过槽位的Formatter不再缓存,每次重新格式化
+type entryOutputs struct {
+	items [maxEntryOutputs]entryOutput
+	size  int
+}
+
+func (o *entryOutputs) get(f Formatter) ([]byte, bool) {
+	for i := 0; i < o.size; i++ {
+		if o.items[i].formatter == f {
+			return o.items[i].data, true
+		}
+	}
+
+	return nil, false
+}
+
+func (o *entryOutputs) add(f Formatter, buf *Buffer, data []byte) {
+	if o.size < maxEntryOutputs {
+		o.items[o.size] = entryOutput{formatter: f, buf: buf, data: data}
+		o.size++
+	}
+}
+
+func (o *entryOutputs) reset() {
+	for i := 0; i < o.size; i++ {
+		if o.items[i].buf != nil {
+			o.items[i].buf.Free()
+		}
+		o.items[i] = entryOutput{}
+	}
+	o.size = 0
+}
+
 // Channel 代表日志输出通路
 type Channel interface {
 	IsEnable(lv Level) bool
@@ -84,6 +191,48 @@ type BatchChannel interface {
 	WriteBatch(msg []*Entry)
 }
 
+// Syncer 可选接口,Channel有缓存数据时实现,Logger.Sync时调用
+type Syncer interface {
+	Sync() error
+}
+
+// Reopener 可选接口,重新打开输出,比如日志文件被外部工具切割后
+type Reopener interface {
+	Reopen() error
+}
+
+// Rotator 可选接口,立即切割日志文件
+type Rotator interface {
+	Rotate() error
+}
+
+// TryWriter 可选接口,写入并返回错误,失败时不调用HandleError,由调用者处理
+// 用于SpoolChannel等需要知道写入结果的包装Channel
+type TryWriter interface {
+	TryWrite(msg *Entry) error
+}
+
+// contextSyncer 异步Channel实现,可以等待队列写完,超时返回错误
+type contextSyncer interface {
+	syncContext(ctx context.Context) error
+	closeContext(ctx context.Context) error
+}
+
+// channelSetup 由BaseChannel实现,创建Logger时注入配置,比如统计信息
+type channelSetup interface {
+	setup(name string, conf *Config)
+}
+
+// channelFilterer 由BaseChannel实现,返回Channel单独配置的Filters
+type channelFilterer interface {
+	channelFilters() []Filter
+}
+
+// asyncOptioner 由BaseChannel实现,Channel配置了WithAsync时使用独立的异步队列
+type asyncOptioner interface {
+	asyncOptions() (AsyncOptions, bool)
+}
+
 // Filter 在每天日志写入Channel前统一预处理,若返回错误则忽略该条日志
 // 可用于通过Context添加Field,对某些Field加密等处理
 type Filter func(*Entry) error
@@ -95,6 +244,12 @@ type Formatter interface {
 	Format(msg *Entry) ([]byte, error) // 格式化输出
 }
 
+// BufferFormatter 可选接口,直接写入Buffer,Buffer由Entry释放时统一回收,避免内存分配
+type BufferFormatter interface {
+	Formatter
+	FormatTo(b *Buffer, msg *Entry) error
+}
+
 // Sampler 日志采样,对于高频的日志可以限制发送频率
 type Sampler interface {
 	Check(msg *Entry) bool
@@ -102,13 +257,20 @@ type Sampler interface {
 
 // Config 配置信息
 type Config struct {
-	Channels      []Channel // 日志输出通路,至少1个,默认Console
-	Filters       []Filter  // 过滤函数
-	Tags          SortedMap // 全局Fields,比如env,cluster,psm,host等
-	Level         Level     // 日志级别,默认Info
-	LogMax        int       // 最大缓存日志数
-	DisableCaller bool      // 是否关闭Caller,若为true则获取不到文件名等信息
-	Async         bool      // 是否异步,默认同步
+	Channels           []Channel      // 日志输出通路,至少1个,默认Console
+	Filters            []Filter       // 过滤函数
+	Tags               SortedMap      // 全局Fields,比如env,cluster,psm,host等
+	Level              Level          // 日志级别,默认Info
+	LogMax             int            // 最大缓存日志数
+	DisableCaller      bool           // 是否关闭Caller,若为true则获取不到文件名等信息
+	Async              bool           // 是否异步,默认同步
+	Overflow           OverflowPolicy // 异步队列满时的处理策略,默认丢弃新日志
+	OverflowTimeout    time.Duration  // OverflowBlockTimeout时的最长等待时间
+	NoDropLevel        Level          // 不低于该级别的日志不会因队列满而丢弃,默认PanicLevel
+	DropReportInterval time.Duration  // 定期汇报异步队列丢弃数量,0则不汇报
+	Queue              QueueType      // 异步队列的实现方式,默认QueueLinked
+	Metrics            *Metrics       // 统计信息,为空时每个Logger单独创建,默认的Logger使用DefaultMetrics
+	ErrorHandler       ErrorHandler   // Channel和Formatter出错时调用,默认DefaultErrorHandler
 }
 
 func (c *Config) AddChannels(channels ...Channel) {
@@ -145,9 +307,12 @@ func NewConfig() *Config {
 type Logger interface {
 	IsEnable(lv Level) bool
 	SetLevel(name string, lv Level)
+	GetLevel(name string) Level
 	Start()
-	Stop()
-	Write(e *Entry)
+	Stop()                                 // 关闭所有Channel,最多等待DefaultStopTimeout
+	StopContext(ctx context.Context) error // 关闭所有Channel,等待异步队列写完直到ctx结束
+	Sync(ctx context.Context) error        // 等待已写入的日志全部输出,并刷新Channel缓存
+	Write(e *Entry)                        // 会释放调用者持有的Entry引用
 	Log(ctx context.Context, lv Level, msg string, fields ...Field)
 	Logf(ctx context.Context, lv Level, format string, args ...interface{})
 	Logw(ctx context.Context, lv Level, msg string, args ...interface{})
@@ -174,13 +339,54 @@ type Logger interface {
 
 // NewLogger 创建默认的Logger
 func NewLogger(config *Config) Logger {
-	l := &logger{Config: config}
+	if config.Metrics == nil {
+		config.Metrics = NewMetrics()
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = DefaultErrorHandler
+	}
+	l := &logger{Config: config, level: int32(config.Level)}
+	for lv := range l.entries {
+		name := strings.ToLower(Level(lv).String())
+		l.entries[lv] = config.Metrics.Counter("glog_entries_total", "Number of log entries written.", "level", name)
+	}
+	l.filtered = config.Metrics.Counter("glog_filtered_total", "Number of log entries discarded by filters.")
+
+	// 配置了独立异步队列的Channel单独处理,互不影响
+	var shared []Channel
+	var asyncs []Channel
+	for _, c := range config.Channels {
+		if cs, ok := c.(channelSetup); ok {
+			cs.setup(c.Name(), config)
+		}
+		if ao, ok := c.(asyncOptioner); ok {
+			if opts, ok := ao.asyncOptions(); ok {
+				asyncs = append(asyncs, newAsyncChannel("async_"+c.Name(), []Channel{c}, opts))
+				continue
+			}
+		}
+		shared = append(shared, c)
+	}
+
 	if config.Async {
-		l.channels = append(l.channels, NewAsyncChannel(l.channels, config.LogMax))
-		l.Start()
+		opts := AsyncOptions{
+			LogMax:         config.LogMax,
+			Overflow:       config.Overflow,
+			Timeout:        config.OverflowTimeout,
+			NoDropLevel:    config.NoDropLevel,
+			ReportInterval: config.DropReportInterval,
+			Queue:          config.Queue,
+		}
+		asyncs = append(asyncs, NewAsyncChannelWithOptions(shared, opts))
 	} else {
-		l.channels = config.Channels
+		l.channels = append(l.channels, shared...)
+	}
+
+	for _, c := range asyncs {
+		c.(channelSetup).setup(c.Name(), config)
+		c.Open()
 	}
+	l.channels = append(l.channels, asyncs...)
 
 	return l
 }
@@ -188,6 +394,9 @@ func NewLogger(config *Config) Logger {
 type logger struct {
 	*Config
 	channels []Channel
+	level    int32                    // 全局日志级别,可以动态修改
+	entries  [TraceLevel + 1]*Counter // 各级别日志数
+	filtered *Counter                 // 被Filter过滤的日志数
 }
 
 func (l *logger) getChannel(name string) Channel {
@@ -201,12 +410,23 @@ func (l *logger) getChannel(name string) Channel {
 }
 
 func (l *logger) IsEnable(lv Level) bool {
-	return lv <= l.Level
+	return int32(lv) <= atomic.LoadInt32(&l.level)
+}
+
+// GetLevel name为空时返回全局日志级别,否则返回对应Channel的级别
+func (l *logger) GetLevel(name string) Level {
+	if name == "" {
+		return Level(atomic.LoadInt32(&l.level))
+	} else if c := l.getChannel(name); c != nil {
+		return c.Level()
+	}
+
+	return Level(atomic.LoadInt32(&l.level))
 }
 
 func (l *logger) SetLevel(name string, lv Level) {
 	if name == "" {
-		l.Level = lv
+		atomic.StoreInt32(&l.level, int32(lv))
 	} else if c := l.getChannel(name); c != nil {
 		c.SetLevel(lv)
 	}
@@ -221,12 +441,64 @@ func (l *logger) Start() {
 
 // Stop stop async logger
 func (l *logger) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+	defer cancel()
+	_ = l.StopContext(ctx)
+}
+
+// StopContext 关闭所有Channel,异步模式下会等待队列写完,返回第一个错误
+func (l *logger) StopContext(ctx context.Context) error {
+	var res error
 	for _, c := range l.channels {
-		c.Close()
+		var err error
+		if cs, ok := c.(contextSyncer); ok {
+			err = cs.closeContext(ctx)
+		} else {
+			err = c.Close()
+		}
+		if err != nil && res == nil {
+			res = err
+		}
 	}
+
+	return res
+}
+
+// Reopen 重新打开所有实现了Reopener的Channel,返回第一个错误
+func (l *logger) Reopen() error {
+	var res error
+	for _, c := range l.Channels {
+		if r, ok := c.(Reopener); ok {
+			if err := r.Reopen(); err != nil && res == nil {
+				res = err
+			}
+		}
+	}
+
+	return res
+}
+
+// Sync 等待调用前写入的日志全部输出,返回第一个错误
+func (l *logger) Sync(ctx context.Context) error {
+	var res error
+	for _, c := range l.channels {
+		var err error
+		if cs, ok := c.(contextSyncer); ok {
+			err = cs.syncContext(ctx)
+		} else if s, ok := c.(Syncer); ok {
+			err = s.Sync()
+		}
+		if err != nil && res == nil {
+			res = err
+		}
+	}
+
+	return res
 }
 
+// Write 写入所有Channel,并释放调用者持有的引用
 func (l *logger) Write(e *Entry) {
+	defer e.Free()
 	if !l.DisableCaller {
 		f := getFrame(e.CallDepth)
 		e.Path = f.File
@@ -238,13 +510,15 @@ func (l *logger) Write(e *Entry) {
 
 	for _, f := range l.Filters {
 		if err := f(e); err != nil {
+			l.filtered.Inc()
 			return
 		}
 	}
+	l.entries[e.Level].Inc()
 
 	for _, c := range l.channels {
 		if c.IsEnable(e.Level) {
-			c.Write(e)
+			writeChannel(c, e)
 		}
 	}
 }
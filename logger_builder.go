@@ -13,7 +13,7 @@ var gBuilderPool = sync.Pool{
 }
 
 func newBuilder(e *Entry) *Builder {
-	b := gBuilderPool.New().(*Builder)
+	b := gBuilderPool.Get().(*Builder)
 	b.entry = e
 	return b
 }
@@ -23,7 +23,12 @@ type Builder struct {
 	entry *Entry
 }
 
-func (b *Builder) free() {
+// free 回收Builder,若日志未写入,则同时释放Entry
+func (b *Builder) free(written bool) {
+	if !written {
+		b.entry.Free()
+	}
+	b.entry = nil
 	gBuilderPool.Put(b)
 }
 
@@ -46,8 +51,10 @@ func (b *Builder) Log(lv Level, args ...interface{}) {
 		e.Level = lv
 		e.Text = text
 		l.Write(e)
+		b.free(true)
+		return
 	}
-	b.free()
+	b.free(false)
 }
 
 func (b *Builder) Logf(lv Level, format string, args ...interface{}) {
@@ -59,8 +66,10 @@ func (b *Builder) Logf(lv Level, format string, args ...interface{}) {
 		e.Level = lv
 		e.Text = text
 		l.Write(e)
+		b.free(true)
+		return
 	}
-	b.free()
+	b.free(false)
 }
 
 func (b *Builder) Logw(lv Level, msg string, args ...interface{}) {
@@ -73,8 +82,10 @@ func (b *Builder) Logw(lv Level, msg string, args ...interface{}) {
 			e.Fields = append(e.Fields, toFields(args)...)
 		}
 		l.Write(e)
+		b.free(true)
+		return
 	}
-	b.free()
+	b.free(false)
 }
 
 func (b *Builder) Trace(ctx context.Context, args ...interface{}) {
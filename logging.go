@@ -15,6 +15,7 @@ func SetDefault(l Logger) {
 // NewDefault 创建默认的Logger,默认只包含Console的输出通路
 func NewDefault() Logger {
 	conf := NewConfig()
+	conf.Metrics = DefaultMetrics
 	conf.Channels = append(conf.Channels, NewConsoleChannel())
 	return NewLogger(conf)
 }
@@ -1,9 +1,23 @@
 package glog
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -31,6 +45,27 @@ func TestDateFormat(t *testing.T) {
 	t.Log(str)
 }
 
+// TestDateFormatStd %d{}和RFC格式按标准格式输出,之前输出为空
+func TestDateFormatStd(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 8000000, time.Local)
+	cases := map[string]string{
+		"%d{}":            now.Format(time.RFC3339),
+		"%d{RFC3339}":     now.Format(time.RFC3339),
+		"%d{RFC3339Nano}": now.Format(time.RFC3339Nano),
+		"%d{RFC1123Z}":    now.Format(time.RFC1123Z),
+		"%d{ISO8601}":     now.Format("2006-01-02T15:04:05-0700"),
+	}
+	for layout, expect := range cases {
+		l := &Layout{}
+		if err := l.Parse(layout); err != nil {
+			t.Fatal(err)
+		}
+		if s := string(l.Format(&Entry{Time: now})); s != expect {
+			t.Errorf("%s: expect %q, got %q", layout, expect, s)
+		}
+	}
+}
+
 func TestLayout(t *testing.T) {
 	l := &Layout{}
 	if err := l.Parse("[%d{yyyy-MM-dd HH:mm:ss.fff}][%p] (%F:%L) - %m%f%n"); err != nil {
@@ -58,6 +93,15 @@ func TestLogging(t *testing.T) {
 	Trace(nil, "hello world", Int("status", 404), String("method", "Get"))
 }
 
+// TestChannelLevel Channel只输出不低于配置级别的日志
+func TestChannelLevel(t *testing.T) {
+	c := &BaseChannel{}
+	c.Init(NewChannelOptions(WithLevel(InfoLevel)))
+	if !c.IsEnable(ErrorLevel) || !c.IsEnable(InfoLevel) || c.IsEnable(DebugLevel) {
+		t.Errorf("invalid level check, error=%v info=%v debug=%v", c.IsEnable(ErrorLevel), c.IsEnable(InfoLevel), c.IsEnable(DebugLevel))
+	}
+}
+
 func TestGraylog(t *testing.T) {
 	conf := NewConfig()
 	conf.AddTags(map[string]string{
@@ -71,3 +115,1562 @@ func TestGraylog(t *testing.T) {
 	logger := NewLogger(conf)
 	logger.Infof(nil, "test glog")
 }
+
+// TestGraylogUdp UDP连接失败时报告错误,之后发送时重连
+func TestGraylogUdp(t *testing.T) {
+	var errs int32
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.ErrorHandler = func(channel string, err error, e *Entry) {
+		atomic.AddInt32(&errs, 1)
+	}
+	c := NewGraylogChannel().(*graylogChannel)
+	c.addr = "127.0.0.1:badport"
+	conf.AddChannels(c)
+	l := NewLogger(conf)
+	l.Info(nil, "lost")
+	l.Info(nil, "lost")
+	if n := atomic.LoadInt32(&errs); n != 2 || !strings.Contains(conf.Metrics.String(), `"glog_write_errors_total{channel=\"graylog\"}":2`) {
+		t.Errorf("udp dial errors should be reported, %d, %s", n, conf.Metrics.String())
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c.mux.Lock()
+	c.addr = pc.LocalAddr().String()
+	c.mux.Unlock()
+	l.Info(nil, "redial")
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, chunkedSize)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil || !bytes.Contains(buf[:n], []byte(`"short_message":"redial"`)) {
+		t.Errorf("should redial on write, %q, %v", buf[:n], err)
+	}
+	l.Stop()
+}
+
+// memoryChannel 记录写入的日志,可通过gate阻塞写入
+type memoryChannel struct {
+	BaseChannel
+	mux   sync.Mutex
+	texts []string
+	gate  chan struct{}
+}
+
+func newMemoryChannel(opts ...ChannelOption) *memoryChannel {
+	c := &memoryChannel{}
+	c.Init(NewChannelOptions(opts...))
+	return c
+}
+
+func (c *memoryChannel) Name() string {
+	return "memory"
+}
+
+func (c *memoryChannel) Write(e *Entry) {
+	if c.gate != nil {
+		<-c.gate
+	}
+	c.mux.Lock()
+	c.texts = append(c.texts, e.Text)
+	c.mux.Unlock()
+}
+
+func (c *memoryChannel) Texts() []string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return append([]string(nil), c.texts...)
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	mc := newMemoryChannel()
+	mc.gate = make(chan struct{})
+	ac := NewAsyncChannelWithOptions([]Channel{mc}, AsyncOptions{
+		LogMax:      2,
+		Overflow:    OverflowDropOldest,
+		NoDropLevel: ErrorLevel,
+		Queue:       QueueRing,
+	}).(*asyncChannel)
+	if ac.ring != nil {
+		t.Fatal("OverflowDropOldest should use linked queue")
+	}
+	ac.Open()
+
+	write := func(lv Level, text string) {
+		e := NewEntry(nil)
+		e.Level = lv
+		e.Text = text
+		ac.Write(e)
+		e.Free()
+	}
+
+	// 第一条被worker取出并阻塞在gate上
+	write(InfoLevel, "first")
+	for {
+		ac.mux.Lock()
+		empty := ac.queue.Empty()
+		ac.mux.Unlock()
+		if empty {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	write(ErrorLevel, "error1")
+	write(DebugLevel, "debug1")
+	write(DebugLevel, "debug2") // 丢弃debug1
+	write(ErrorLevel, "error2") // 不会丢弃,超过LogMax
+
+	if n := ac.Dropped(DebugLevel); n != 1 {
+		t.Errorf("dropped debug %d, want 1", n)
+	}
+
+	close(mc.gate)
+	ac.Close()
+	texts := mc.Texts()
+	want := []string{"first", "error1", "debug2", "error2"}
+	if len(texts) != len(want)+1 {
+		t.Fatalf("unexpected output, %+v", texts)
+	}
+	for i, s := range want {
+		if texts[i] != s {
+			t.Errorf("unexpected output, %+v", texts)
+			break
+		}
+	}
+}
+
+// TestAsyncDropReport 队列满时丢弃的日志数在退出前按级别汇报
+func TestAsyncDropReport(t *testing.T) {
+	fc := &fieldsChannel{}
+	fc.Init(NewChannelOptions())
+	fc.gate = make(chan struct{})
+	ac := NewAsyncChannelWithOptions([]Channel{fc}, AsyncOptions{LogMax: 1}).(*asyncChannel)
+	ac.Open()
+
+	write := func(lv Level, text string) {
+		e := NewEntry(nil)
+		e.Level = lv
+		e.Text = text
+		ac.Write(e)
+		e.Free()
+	}
+
+	// 第一条被worker取出并阻塞在gate上,第二条占满队列
+	write(InfoLevel, "first")
+	for ac.Len() != 0 {
+		runtime.Gosched()
+	}
+	write(InfoLevel, "second")
+	write(DebugLevel, "debug1")
+	write(DebugLevel, "debug2")
+	write(InfoLevel, "info")
+
+	close(fc.gate)
+	ac.Close()
+
+	texts := fc.Texts()
+	if len(texts) != 3 || texts[0] != "first" || texts[1] != "second" || texts[2] != "glog: async queue overflow, entries dropped" {
+		t.Fatalf("unexpected output, %+v", texts)
+	}
+	dropped := map[string]int64{}
+	for _, f := range fc.fields[2] {
+		dropped[f.Key] = f.Int
+	}
+	if len(dropped) != 2 || dropped["dropped_debug"] != 2 || dropped["dropped_info"] != 1 {
+		t.Errorf("unexpected report, %+v", fc.fields[2])
+	}
+
+	// 已经汇报过的不再汇报
+	ac.mux.Lock()
+	report := ac.newReport()
+	ac.mux.Unlock()
+	if report != nil {
+		t.Errorf("should not report twice, %+v", report.Fields)
+	}
+}
+
+// TestAsyncRingClose 关闭时并发写入的日志要么写出,要么计入丢弃数
+func TestAsyncRingClose(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		mc := newMemoryChannel()
+		ac := NewAsyncChannelWithOptions([]Channel{mc}, AsyncOptions{
+			LogMax:   64,
+			Overflow: OverflowBlock,
+			Queue:    QueueRing,
+		}).(*asyncChannel)
+		ac.Open()
+
+		const producers, count = 4, 200
+		wg := sync.WaitGroup{}
+		for i := 0; i < producers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < count; n++ {
+					e := NewEntry(nil)
+					e.Level = InfoLevel
+					e.Text = "ring"
+					ac.Write(e)
+					e.Free()
+				}
+			}()
+		}
+		runtime.Gosched()
+		ac.Close()
+		wg.Wait()
+
+		// 有丢弃时退出前会额外写一条汇报
+		n := ac.Dropped(InfoLevel)
+		for _, text := range mc.Texts() {
+			if text == "ring" {
+				n++
+			}
+		}
+		if n != producers*count {
+			t.Fatalf("round %d: written and dropped %d, want %d", round, n, producers*count)
+		}
+	}
+}
+
+// TestAsyncCloseUnopened 未启动就关闭后,再次Close和Sync不会阻塞
+func TestAsyncCloseUnopened(t *testing.T) {
+	ac := NewAsyncChannel([]Channel{newMemoryChannel()}, 8)
+	done := make(chan struct{})
+	go func() {
+		_ = ac.Close()
+		_ = ac.Close()
+		_ = ac.(Syncer).Sync()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close and sync should not block after closing an unopened channel")
+	}
+}
+
+func TestAsyncSync(t *testing.T) {
+	t.Run("linked", func(t *testing.T) { testAsyncSync(t, QueueLinked) })
+	t.Run("ring", func(t *testing.T) { testAsyncSync(t, QueueRing) })
+}
+
+func testAsyncSync(t *testing.T, queue QueueType) {
+	mc := newMemoryChannel()
+	conf := NewConfig()
+	conf.Async = true
+	conf.Queue = queue
+	conf.Overflow = OverflowBlock
+	conf.LogMax = 16
+	conf.AddChannels(mc)
+	l := NewLogger(conf)
+
+	for i := 0; i < 100; i++ {
+		l.Infof(nil, "sync %d", i)
+	}
+	if err := l.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mc.Texts()); n != 100 {
+		t.Errorf("sync not drained, got %d", n)
+	}
+
+	l.Infof(nil, "before stop")
+	if err := l.StopContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mc.Texts()); n != 101 {
+		t.Errorf("stop not drained, got %d", n)
+	}
+}
+
+func TestAsyncIsolation(t *testing.T) {
+	slow := newMemoryChannel(WithAsync(10))
+	slow.gate = make(chan struct{})
+	fast := newMemoryChannel(WithAsync(100))
+	conf := NewConfig()
+	conf.AddChannels(slow, fast)
+	l := NewLogger(conf)
+
+	for i := 0; i < 20; i++ {
+		l.Infof(nil, "isolation %d", i)
+	}
+
+	// slow阻塞时不影响fast
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, c := range l.(*logger).channels {
+		if ac := c.(*asyncChannel); ac.channels[0] == fast {
+			if err := ac.syncContext(ctx); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+	if n := len(fast.Texts()); n != 20 {
+		t.Errorf("fast channel blocked, got %d", n)
+	}
+
+	close(slow.gate)
+	l.Stop()
+	if n := len(slow.Texts()); n >= 20 {
+		t.Errorf("slow channel should drop entries, got %d", n)
+	}
+}
+
+// batchMemoryChannel 记录每批的大小
+type batchMemoryChannel struct {
+	memoryChannel
+	batches []int
+	sent    chan int // 非nil时每批发送后通知
+}
+
+func (c *batchMemoryChannel) WriteBatch(msgs []*Entry) {
+	c.mux.Lock()
+	c.batches = append(c.batches, len(msgs))
+	c.mux.Unlock()
+	if c.sent != nil {
+		c.sent <- len(msgs)
+	}
+}
+
+func TestAsyncBatch(t *testing.T) {
+	bc := &batchMemoryChannel{sent: make(chan int, 8)}
+	bc.Init(NewChannelOptions(WithBatch(3), WithLinger(50*time.Millisecond)))
+	conf := NewConfig()
+	conf.Async = true
+	conf.AddChannels(bc)
+	l := NewLogger(conf)
+	defer l.Stop()
+
+	for i := 0; i < 7; i++ {
+		l.Infof(nil, "batch %d", i)
+	}
+	// 剩余1条等待Linger后发送,不需要Stop触发
+	var batches []int
+	for len(batches) < 3 {
+		select {
+		case n := <-bc.sent:
+			batches = append(batches, n)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("linger not triggered, %+v", batches)
+		}
+	}
+	if batches[0] != 3 || batches[1] != 3 || batches[2] != 1 {
+		t.Errorf("unexpected batches, %+v", batches)
+	}
+}
+
+func TestRingQueue(t *testing.T) {
+	q := NewRingQueue(5)
+	if q.Cap() != 5 {
+		t.Fatalf("cap should be 5, got %d", q.Cap())
+	}
+	e := &Entry{}
+	for i := 0; i < 5; i++ {
+		if !q.Push(e) {
+			t.Fatalf("push %d failed", i)
+		}
+	}
+	if q.Push(e) {
+		t.Fatal("push should fail when full")
+	}
+	for q.Pop() != nil {
+	}
+
+	const producers, count = 8, 1000
+	wg := sync.WaitGroup{}
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < count; n++ {
+				for !q.Push(e) {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+
+	total := 0
+	for total < producers*count {
+		if n := q.Len(); n > 5 {
+			t.Fatalf("len %d exceeds cap", n)
+		}
+		if q.Pop() != nil {
+			total++
+		} else {
+			runtime.Gosched()
+		}
+	}
+	wg.Wait()
+	if !q.Empty() {
+		t.Error("queue should be empty")
+	}
+}
+
+// signalLogger 通知信号处理对Logger的调用
+type signalLogger struct {
+	Logger
+	calls chan string
+}
+
+func (l *signalLogger) SetLevel(name string, lv Level) {
+	l.Logger.SetLevel(name, lv)
+	l.calls <- "level " + lv.String()
+}
+
+func (l *signalLogger) Reopen() error {
+	l.calls <- "reopen"
+	return nil
+}
+
+func (l *signalLogger) StopContext(ctx context.Context) error {
+	err := l.Logger.StopContext(ctx)
+	l.calls <- "stop"
+	return err
+}
+
+func (l *signalLogger) wait(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case call := <-l.calls:
+		if call != want {
+			t.Errorf("unexpected call %q, want %q", call, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for %q", want)
+	}
+}
+
+func newSignalLogger() *signalLogger {
+	conf := NewConfig()
+	conf.Level = InfoLevel
+	return &signalLogger{Logger: NewLogger(conf), calls: make(chan string, 8)}
+}
+
+func TestSignalLevelSwitch(t *testing.T) {
+	l := newSignalLogger()
+	h := newSignalHandler(l, SignalOptions{LevelDuration: 20 * time.Millisecond})
+
+	h.switchLevel(1)
+	l.wait(t, "level "+DebugLevel.String())
+	h.switchLevel(-1)
+	l.wait(t, "level "+InfoLevel.String())
+	h.switchLevel(-1)
+	l.wait(t, "level "+WarnLevel.String())
+
+	// 经过LevelDuration后恢复
+	l.wait(t, "level "+InfoLevel.String())
+	if lv := l.GetLevel(""); lv != InfoLevel {
+		t.Errorf("level should be restored, got %v", lv)
+	}
+}
+
+func TestSignalHandler(t *testing.T) {
+	l := newSignalLogger()
+	h := newSignalHandler(l, SignalOptions{Shutdown: true, Reopen: true})
+	if len(h.signals()) != 3 {
+		t.Errorf("unexpected signals, %v", h.signals())
+	}
+	exited := make(chan os.Signal, 1)
+	h.exit = func(sig os.Signal) {
+		exited <- sig
+	}
+	ch := make(chan os.Signal, 1)
+	stop := h.run(ch)
+	defer stop()
+
+	ch <- syscall.SIGHUP
+	l.wait(t, "reopen")
+
+	ch <- syscall.SIGTERM
+	l.wait(t, "stop")
+	select {
+	case sig := <-exited:
+		if sig != syscall.SIGTERM {
+			t.Errorf("unexpected exit signal, %v", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("should exit after shutdown")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	conf := NewConfig()
+	conf.Metrics = m
+	conf.Async = true
+	conf.AddChannels(newMemoryChannel())
+	l := NewLogger(conf)
+	l.Info(nil, "metrics")
+	l.Info(nil, "metrics")
+	l.Error(nil, "metrics")
+	l.Stop()
+
+	buf := &bytes.Buffer{}
+	if err := m.WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, s := range []string{
+		"# TYPE glog_entries_total counter\n",
+		`glog_entries_total{level="info"} 2` + "\n",
+		`glog_entries_total{level="error"} 1` + "\n",
+		`glog_async_dropped_total{queue="async",level="info"} 0` + "\n",
+		`glog_write_errors_total{channel="memory"} 0` + "\n",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("metrics not contains %q\n%s", s, text)
+		}
+	}
+
+	if !strings.Contains(m.String(), `"glog_entries_total{level=\"info\"}":2`) {
+		t.Errorf("invalid expvar, %s", m.String())
+	}
+
+	// 未指定时每个Logger使用单独的统计信息
+	c1, c2 := NewConfig(), NewConfig()
+	NewLogger(c1).Info(nil, "own")
+	NewLogger(c2)
+	if c1.Metrics == nil || c1.Metrics == c2.Metrics || c1.Metrics == DefaultMetrics ||
+		!strings.Contains(c1.Metrics.String(), `"glog_entries_total{level=\"info\"}":1`) {
+		t.Errorf("logger should own its metrics, %s", c1.Metrics.String())
+	}
+}
+
+type errorFormatter struct{}
+
+func (errorFormatter) Name() string {
+	return "error"
+}
+
+func (errorFormatter) Format(msg *Entry) ([]byte, error) {
+	return nil, fmt.Errorf("bad entry")
+}
+
+func TestErrorHandler(t *testing.T) {
+	var mux sync.Mutex
+	var errs []string
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.ErrorHandler = func(channel string, err error, e *Entry) {
+		mux.Lock()
+		errs = append(errs, channel+": "+err.Error()+": "+e.Text)
+		mux.Unlock()
+	}
+	conf.AddChannels(NewConsoleChannel(WithFormatter(errorFormatter{})))
+	l := NewLogger(conf)
+	l.Info(nil, "hello")
+	l.Stop()
+
+	if len(errs) != 1 || errs[0] != "console: format error: bad entry: hello" {
+		t.Errorf("invalid errors, %q", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	h := NewRateLimitedErrorHandler(buf, time.Hour)
+	for i := 0; i < 3; i++ {
+		h("file", fmt.Errorf("disk full"), nil)
+	}
+	h("elastic", fmt.Errorf("timeout"), nil)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], `channel "file": disk full`) {
+		t.Errorf("invalid output, %q", lines)
+	}
+
+	// interval结束后输出忽略的错误数,不需要等到下次出错
+	out := make(chanWriter, 4)
+	h = NewRateLimitedErrorHandler(out, 20*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		h("file", fmt.Errorf("disk full %d", i), nil)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-out:
+			if i == 1 && !strings.HasSuffix(line, `channel "file": disk full 2 (2 errors suppressed)`+"\n") {
+				t.Errorf("invalid suppressed output, %q", line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("suppressed errors not flushed")
+		}
+	}
+
+	// 未加入Logger时也使用Channel的名字报告错误
+	fc := NewFailoverChannel(newMemoryChannel(), newMemoryChannel()).(*failoverChannel)
+	if c := NewConsoleChannel().(*consoleChannel); c.name != "console" || fc.name != "failover_memory" {
+		t.Errorf("invalid channel name, %q, %q", c.name, fc.name)
+	}
+}
+
+// chanWriter 每次Write发送到chan
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestRetry(t *testing.T) {
+	var mux sync.Mutex
+	var codes []int
+	status := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent, http.StatusBadRequest}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		code := status[len(codes)%len(status)]
+		codes = append(codes, code)
+		mux.Unlock()
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	var errs []error
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.ErrorHandler = func(channel string, err error, e *Entry) {
+		errs = append(errs, err)
+	}
+	conf.AddChannels(NewElasticChannel(WithURL(srv.URL), WithRetryOptions(RetryOptions{Max: 3, Initial: time.Millisecond})))
+	l := NewLogger(conf)
+	l.Info(nil, "retry")
+	if len(codes) != 3 || len(errs) != 0 {
+		t.Errorf("should succeed after retry, codes=%v, errs=%v", codes, errs)
+	}
+
+	// 4xx不重试
+	l.Info(nil, "retry")
+	l.Stop()
+	if len(codes) != 4 || len(errs) != 1 || IsRetryable(errs[0]) {
+		t.Errorf("4xx should not retry, codes=%v, errs=%v", codes, errs)
+	}
+
+	now := time.Now()
+	if d := parseRetryAfter("120", now); d != time.Minute*2 {
+		t.Errorf("invalid retry after, %v", d)
+	}
+	if d := parseRetryAfter(now.Add(time.Minute).UTC().Format(http.TimeFormat), now); d <= 0 || d > time.Minute {
+		t.Errorf("invalid retry after, %v", d)
+	}
+
+	// Retry-After不超过MaxDelay
+	calls := 0
+	o := RetryOptions{Max: 1, MaxDelay: time.Millisecond}
+	_ = o.Do(func() error {
+		calls++
+		return &HTTPError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}
+	})
+	if calls != 2 || time.Since(now) > time.Second*10 {
+		t.Errorf("retry after should be limited by max delay, %v, %v", calls, time.Since(now))
+	}
+}
+
+// flakyChannel 可以模拟写入失败的memoryChannel
+type flakyChannel struct {
+	memoryChannel
+	fail int32
+}
+
+func newFlakyChannel(fail bool) *flakyChannel {
+	c := &flakyChannel{}
+	c.Init(NewChannelOptions())
+	if fail {
+		c.fail = 1
+	}
+	return c
+}
+
+func (c *flakyChannel) Name() string {
+	return "flaky"
+}
+
+func (c *flakyChannel) TryWrite(e *Entry) error {
+	if atomic.LoadInt32(&c.fail) == 1 {
+		return fmt.Errorf("channel down")
+	}
+	c.memoryChannel.Write(e)
+	return nil
+}
+
+func (c *flakyChannel) Write(e *Entry) {
+	_ = c.TryWrite(e)
+}
+
+func newSpoolLogger(inner Channel, dir string, maxBytes int64, opts ...ChannelOption) (Logger, *spoolChannel) {
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.ErrorHandler = func(channel string, err error, e *Entry) {}
+	opts = append([]ChannelOption{WithSegmentBytes(256), WithReplayInterval(time.Millisecond * 10)}, opts...)
+	spool := NewSpoolChannel(inner, dir, maxBytes, opts...)
+	conf.AddChannels(spool)
+	return NewLogger(conf), spool.(*spoolChannel)
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog_spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	down := newFlakyChannel(true)
+	l, _ := newSpoolLogger(down, dir, 1<<20)
+	for i := 0; i < 20; i++ {
+		l.Info(nil, fmt.Sprintf("msg%02d", i), Int("index", i))
+	}
+	l.Stop()
+	if len(down.Texts()) != 0 {
+		t.Fatalf("should not write to inner")
+	}
+
+	// 重启后继续发送,新日志排在积压的日志后面
+	up := newFlakyChannel(false)
+	l, spool := newSpoolLogger(up, dir, 1<<20)
+	l.Info(nil, "msg20")
+	for i := 0; i < 200 && len(up.Texts()) < 21; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	texts := up.Texts()
+	if len(texts) != 21 {
+		t.Fatalf("replay fail, %v", texts)
+	}
+	for i, text := range texts {
+		if text != fmt.Sprintf("msg%02d", i) {
+			t.Fatalf("invalid order, %v", texts)
+		}
+	}
+	if spool.Size() != 0 {
+		t.Errorf("spool should be empty, %v", spool.Size())
+	}
+	l.Info(nil, "direct")
+	l.Stop()
+	if texts := up.Texts(); texts[len(texts)-1] != "direct" {
+		t.Errorf("should write directly, %v", texts)
+	}
+
+	// 超过上限删除最旧的分段
+	l, spool = newSpoolLogger(down, dir, 1024)
+	for i := 0; i < 100; i++ {
+		l.Info(nil, "overflow")
+	}
+	if spool.Size() > 1024 || spool.dropped.Value() == 0 {
+		t.Errorf("should drop oldest, size=%v, dropped=%v", spool.Size(), spool.dropped.Value())
+	}
+	l.Stop()
+
+	// inner写入太慢时之后的日志写入磁盘
+	dir = filepath.Join(dir, "slow")
+	slow := newFlakyChannel(false)
+	slow.gate = make(chan struct{})
+	l, spool = newSpoolLogger(slow, dir, 1<<20, WithSlowWrite(time.Millisecond*5), WithReplayInterval(time.Hour))
+	time.AfterFunc(time.Millisecond*20, func() { close(slow.gate) })
+	l.Info(nil, "slow")
+	l.Info(nil, "spooled")
+	if texts := slow.Texts(); len(texts) != 1 || spool.Size() == 0 {
+		t.Errorf("should spool when inner is slow, %v, %v", texts, spool.Size())
+	}
+	l.Stop()
+
+	// 关闭后不再写入
+	spool.Write(&Entry{Text: "closed"})
+	if spool.file != nil || len(slow.Texts()) != 1 {
+		t.Errorf("should not write after close")
+	}
+
+	// 重启后只打开,没有新日志也会发送
+	up = newFlakyChannel(false)
+	l, spool = newSpoolLogger(up, dir, 1<<20)
+	if err := spool.Open(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200 && len(up.Texts()) < 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if texts := up.Texts(); !reflect.DeepEqual(texts, []string{"spooled"}) {
+		t.Errorf("should replay spooled entries, %v", texts)
+	}
+	l.Stop()
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var mux sync.Mutex
+	var reports []string
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.ErrorHandler = func(channel string, err error, e *Entry) {
+		mux.Lock()
+		reports = append(reports, err.Error())
+		mux.Unlock()
+	}
+	inner := newFlakyChannel(true)
+	fallback := newMemoryChannel()
+	breaker := NewCircuitBreakerChannel(inner, BreakerOptions{Failures: 3, OpenDuration: time.Millisecond * 20, Fallback: fallback})
+	conf.AddChannels(breaker)
+	l := NewLogger(conf)
+	for i := 0; i < 5; i++ {
+		l.Info(nil, "down")
+	}
+	if s := breaker.(*breakerChannel).State(); s != BreakerOpen || len(fallback.Texts()) != 2 {
+		t.Fatalf("should open, state=%v, fallback=%v", s, fallback.Texts())
+	}
+
+	atomic.StoreInt32(&inner.fail, 0)
+	time.Sleep(time.Millisecond * 30)
+	l.Info(nil, "up")
+	if s := breaker.(*breakerChannel).State(); s != BreakerClosed || len(inner.Texts()) != 1 {
+		t.Fatalf("should close, state=%v, texts=%v", s, inner.Texts())
+	}
+	l.Stop()
+
+	mux.Lock()
+	if !strings.Contains(strings.Join(reports, "\n"), "circuit breaker closed -> open: channel down") {
+		t.Errorf("should report state changes, %q", reports)
+	}
+	mux.Unlock()
+
+	// 写入阻塞时超时熔断
+	hung := newMemoryChannel()
+	hung.gate = make(chan struct{})
+	breaker = NewCircuitBreakerChannel(hung, BreakerOptions{Failures: 1, Timeout: time.Millisecond * 10})
+	if err := breaker.(TryWriter).TryWrite(NewEntry(nil)); err != ErrWriteTimeout {
+		t.Errorf("should timeout, %v", err)
+	}
+	if err := breaker.(TryWriter).TryWrite(NewEntry(nil)); err != ErrCircuitOpen {
+		t.Errorf("should open, %v", err)
+	}
+	close(hung.gate)
+}
+
+func TestFailover(t *testing.T) {
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.ErrorHandler = func(channel string, err error, e *Entry) {}
+	primary := newFlakyChannel(true)
+	secondary := newMemoryChannel()
+	failover := NewFailoverChannelWithOptions(FailoverOptions{CheckInterval: time.Millisecond * 20}, primary, secondary)
+	conf.AddChannels(failover)
+	l := NewLogger(conf)
+	l.Info(nil, "a")
+	l.Info(nil, "b")
+	fc := failover.(*failoverChannel)
+	if fc.Active() != "memory" || len(secondary.Texts()) != 2 {
+		t.Fatalf("should switch to secondary, active=%v, texts=%v", fc.Active(), secondary.Texts())
+	}
+
+	atomic.StoreInt32(&primary.fail, 0)
+	l.Info(nil, "c")
+	time.Sleep(time.Millisecond * 30)
+	l.Info(nil, "d")
+	l.Stop()
+	if fc.Active() != "flaky" || len(primary.Texts()) != 1 || len(secondary.Texts()) != 3 {
+		t.Errorf("should switch back, active=%v, primary=%v, secondary=%v", fc.Active(), primary.Texts(), secondary.Texts())
+	}
+
+	buf := &bytes.Buffer{}
+	_ = conf.Metrics.WritePrometheus(buf)
+	for _, s := range []string{
+		`glog_failover_active{channel="failover_flaky",target="flaky"} 1`,
+		`glog_failover_active{channel="failover_flaky",target="memory"} 0`,
+		`glog_failover_switches_total{channel="failover_flaky"} 2`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("metrics not contains %q\n%s", s, buf.String())
+		}
+	}
+
+	// 健康检查,写入和关闭可以并发执行
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ec := NewElasticChannel(WithURL(srv.URL))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			_ = ec.(HealthChecker).HealthCheck()
+		}()
+		go func() {
+			defer wg.Done()
+			_ = ec.(TryWriter).TryWrite(&Entry{Text: "health"})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = ec.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRouter(t *testing.T) {
+	audit := newMemoryChannel()
+	graylog := newMemoryChannel()
+	errors := newMemoryChannel()
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.AddChannels(NewRouterChannel(
+		NewRoute(MatchCategory("audit"), audit),
+		NewRoute(And(MatchLevels(PanicLevel, ErrorLevel), MatchMessage("^db:")), errors),
+		DefaultRoute(graylog),
+	))
+	l := NewLogger(conf)
+	l.Info(nil, "login", Category("audit"), String("user", "tom"))
+	l.Error(nil, "db: timeout")
+	l.Info(nil, "hello")
+	l.Stop()
+
+	if !reflect.DeepEqual(audit.Texts(), []string{"login"}) ||
+		!reflect.DeepEqual(errors.Texts(), []string{"db: timeout"}) ||
+		!reflect.DeepEqual(graylog.Texts(), []string{"hello"}) {
+		t.Errorf("invalid route, audit=%v, errors=%v, graylog=%v", audit.Texts(), errors.Texts(), graylog.Texts())
+	}
+
+	// 全部匹配时同一个Channel只写入一次
+	all := newMemoryChannel()
+	router := NewRouterChannelWithMode(RouteAllMatch,
+		NewRoute(MatchField("user"), all),
+		NewRoute(MatchFieldValue("status", "404"), all, audit),
+	)
+	e := NewEntry(nil)
+	e.Text = "all"
+	e.Fields = []Field{String("user", "tom"), Int("status", 404)}
+	router.Write(e)
+	e.Free()
+	if len(all.Texts()) != 1 || len(audit.Texts()) != 2 {
+		t.Errorf("invalid all match, all=%v, audit=%v", all.Texts(), audit.Texts())
+	}
+}
+
+// fieldsChannel 记录写入的Field
+type fieldsChannel struct {
+	memoryChannel
+	keys   [][]string
+	fields [][]Field
+}
+
+func (c *fieldsChannel) Write(e *Entry) {
+	var keys []string
+	for _, f := range e.Fields {
+		keys = append(keys, f.Key)
+	}
+	c.mux.Lock()
+	c.keys = append(c.keys, keys)
+	c.fields = append(c.fields, append([]Field(nil), e.Fields...))
+	c.mux.Unlock()
+	c.memoryChannel.Write(e)
+}
+
+func TestChannelFilters(t *testing.T) {
+	stdout := newMemoryChannel(WithMaxLevel(InfoLevel))
+	stderr := newMemoryChannel(WithLevel(WarnLevel))
+	stripDebug := func(e *Entry) error {
+		fields := e.Fields[:0]
+		for _, f := range e.Fields {
+			if !strings.HasPrefix(f.Key, "debug_") {
+				fields = append(fields, f)
+			}
+		}
+		e.Fields = fields
+		return nil
+	}
+	elastic := &fieldsChannel{}
+	elastic.Init(NewChannelOptions(WithFilters(stripDebug)))
+	all := &fieldsChannel{}
+	all.Init(NewChannelOptions())
+
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.AddChannels(stdout, stderr, elastic, all)
+	l := NewLogger(conf)
+	l.Debug(nil, "debug", String("debug_sql", "select 1"), Int("status", 200))
+	l.Warn(nil, "warn")
+	l.Stop()
+
+	if !reflect.DeepEqual(stdout.Texts(), []string{"debug"}) || !reflect.DeepEqual(stderr.Texts(), []string{"warn"}) {
+		t.Errorf("invalid level range, stdout=%v, stderr=%v", stdout.Texts(), stderr.Texts())
+	}
+	if !reflect.DeepEqual(elastic.keys[0], []string{"status"}) || !reflect.DeepEqual(all.keys[0], []string{"debug_sql", "status"}) {
+		t.Errorf("filters should only affect own channel, elastic=%v, all=%v", elastic.keys, all.keys)
+	}
+}
+
+func TestWriterChannel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.AddChannels(NewWriterChannel(buf, WithLayout("%p %m"), WithBufferSize(1024)))
+	l := NewLogger(conf)
+	l.Info(nil, "hello")
+	l.Warn(nil, "world")
+	if buf.Len() != 0 {
+		t.Errorf("should be buffered, %q", buf.String())
+	}
+	if err := l.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "INFO hello\nWARN world\n" {
+		t.Errorf("invalid output, %q", buf.String())
+	}
+	l.Stop()
+
+	c := NewConsoleChannel(WithSplit(WarnLevel)).(*consoleChannel)
+	if c.output(ErrorLevel) != c.stderr || c.output(WarnLevel) != c.stderr || c.output(InfoLevel) != c.stdout {
+		t.Errorf("invalid split output")
+	}
+	if c.stdout.w != os.Stdout || c.stderr.w != os.Stderr || isTerminal(buf) {
+		t.Errorf("invalid console writer")
+	}
+}
+
+func TestLayoutColor(t *testing.T) {
+	os.Unsetenv("NO_COLOR")
+	l, err := NewLayout("%highlight{%p}{ERROR=bold red} %color{dim}{%m}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Entry{Level: ErrorLevel, Text: "msg"}
+	if text := string(l.Format(e)); text != "\x1b[1;31mERROR\x1b[0m \x1b[2mmsg\x1b[0m" {
+		t.Errorf("invalid color layout, %q", text)
+	}
+
+	b := NewBuffer()
+	if text := string(stripColor(b, l.Format(e))); text != "ERROR msg" {
+		t.Errorf("invalid strip color, %q", text)
+	}
+	b.Free()
+
+	// 不支持颜色的Writer删除颜色
+	buf := &bytes.Buffer{}
+	w := newLineWriter(buf, NewChannelOptions())
+	if err := w.WriteLine(l.Format(e)); err != nil || buf.String() != "ERROR msg\n" {
+		t.Errorf("invalid line writer, %q", buf.String())
+	}
+
+	if s, err := ParseStyle("bg:196 #ff8800"); err != nil || s.start != "\x1b[48;5;196;38;2;255;136;0m" {
+		t.Errorf("invalid style, %q, %v", s.start, err)
+	}
+	if _, err := ParseStyle("bold pink"); err == nil {
+		t.Errorf("should be invalid style")
+	}
+	if _, err := ParseColorScheme("NOTICE=red"); err == nil {
+		t.Errorf("should be invalid scheme")
+	}
+	if _, err := NewLayout("%color{red}"); err == nil {
+		t.Errorf("color should need pattern")
+	}
+
+	// 文件不写入颜色
+	dir, err := ioutil.TempDir("", "glog_color")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "color.log")
+	fc := NewFileChannel(WithFile(path), WithLayout("%highlight{%p}{ERROR=bold red} %color{dim}{%m}%n"))
+	fc.Write(e)
+	_ = fc.Close()
+	if data, _ := ioutil.ReadFile(path); string(data) != "ERROR msg\n" {
+		t.Errorf("file should not contain color, %q", data)
+	}
+
+	// TERM=dumb时控制台删除颜色
+	term := os.Getenv("TERM")
+	os.Setenv("TERM", "dumb")
+	if ColorEnabled(os.Stdout) {
+		t.Errorf("TERM=dumb should disable color")
+	}
+	os.Setenv("TERM", term)
+
+	// 整行着色写入缓存
+	cc := NewConsoleChannel().(*consoleChannel)
+	b = NewBuffer()
+	if text := string(cc.AddColor(b, ErrorLevel, []byte("msg\n"))); text != "\x1b[31mmsg\x1b[0m\n" {
+		t.Errorf("invalid console color, %q", text)
+	}
+	b.Free()
+
+	os.Setenv("NO_COLOR", "1")
+	defer os.Unsetenv("NO_COLOR")
+	if ColorEnabled(os.Stdout) {
+		t.Errorf("NO_COLOR should disable color")
+	}
+	l, _ = NewLayout("%highlight{%p} %m")
+	if text := string(l.Format(e)); text != "ERROR msg" {
+		t.Errorf("NO_COLOR should disable layout color, %q", text)
+	}
+}
+
+func TestPrettyFormatter(t *testing.T) {
+	if _, ok := NewPrettyFormatter(&bytes.Buffer{}).(*textFormatter); !ok {
+		t.Errorf("should use text formatter when not a terminal")
+	}
+
+	f, err := NewPrettyFormatterWithOptions(PrettyOptions{Width: 60, CallerWidth: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Entry{
+		Level: WarnLevel,
+		Text:  "hello\nworld",
+		Time:  time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.Local),
+		File:  "main.go",
+		Line:  12,
+		Fields: []Field{
+			Int("count", 1), String("name", "glog"), String("long", strings.Repeat("x", 20)),
+			Any("error", fmt.Errorf("boom")), String("stack", "main.main()\n\tmain.go:12"),
+		},
+	}
+	data, _ := f.Format(e)
+	expect := "03:04:05.006 WARN  main.go:12 hello\n" +
+		"                              world count=1 name=glog\n" +
+		"                              long=xxxxxxxxxxxxxxxxxxxx\n" +
+		"    error: boom\n" +
+		"    stack:\n" +
+		"        main.main()\n" +
+		"        \tmain.go:12\n"
+	if string(data) != expect {
+		t.Errorf("invalid pretty output\n%s", data)
+	}
+
+	f, _ = NewPrettyFormatterWithOptions(PrettyOptions{Color: true})
+	data, _ = f.Format(e)
+	if !strings.Contains(string(data), "\x1b[2mcount=\x1b[0m\x1b[36m1\x1b[0m") || !strings.Contains(string(data), "\x1b[33mWARN\x1b[0m ") {
+		t.Errorf("invalid pretty color, %q", data)
+	}
+}
+
+func TestFileRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后追加,不覆盖
+	c := NewFileChannel(WithFile(path), WithLayout("%m%n"))
+	c.Write(&Entry{Text: "new"})
+	_ = c.Close()
+	if data, _ := ioutil.ReadFile(path); string(data) != "old\nnew\n" {
+		t.Errorf("should append, %q", data)
+	}
+
+	conf := NewConfig()
+	conf.Metrics = NewMetrics()
+	conf.AddChannels(NewFileChannel(WithFile(path), WithLayout("%m%n"), WithMaxSize(10), WithMaxBackups(2)))
+	l := NewLogger(conf)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				l.Infof(nil, "%d-%d", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	l.Stop()
+
+	data, _ := ioutil.ReadFile(path + ".2")
+	names, _ := filepath.Glob(path + "*")
+	if len(names) != 3 || fileExists(path+".3") || len(data) == 0 || len(data) > 10 {
+		t.Errorf("invalid backups, %v, %q", names, data)
+	}
+
+	f, _ := newLogFile(filepath.Join(dir, "ts.log"), NewChannelOptions(WithMaxSize(4), WithBackupNaming(BackupTimestamp), WithMaxBackups(1)))
+	for i := 0; i < 3; i++ {
+		if _, err := f.write([]byte("1234")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = f.close()
+	if backups := f.listBackups(); len(backups) != 1 || !strings.HasPrefix(backups[0].stamp, time.Now().Format("2006-01-02T")) {
+		t.Errorf("invalid timestamp backups, %+v", backups)
+	}
+}
+
+func TestFileTimeRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog_time_rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	loc := time.FixedZone("UTC+8", 8*3600)
+	if p, err := newFilePattern("app-%d{yyyyMMdd}.log", loc); err != nil || p.period != periodDay {
+		t.Fatalf("invalid pattern, %+v, %v", p, err)
+	}
+	// 按东八区计算,UTC 16:00为第二天的0点
+	now := time.Date(2026, 10, 17, 15, 59, 59, 0, time.UTC)
+	p, _ := newFilePattern("app-%d{yyyyMMdd}.log", loc)
+	if next := p.next(now); !next.Equal(time.Date(2026, 10, 17, 16, 0, 0, 0, time.UTC)) || p.format(now) != "app-20261017.log" {
+		t.Errorf("invalid period, %v, %v", next, p.format(now))
+	}
+	if _, err := newFilePattern("app-%d{yyyyMMdd}-%p.log", loc); err == nil {
+		t.Errorf("should be invalid pattern")
+	}
+
+	f, err := newLogFile(filepath.Join(dir, "app-%d{yyyyMMdd-HH}.log"), NewChannelOptions(WithTimeZone(time.UTC), WithSymlink(filepath.Join(dir, "app.log"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return now }
+	_, _ = f.write([]byte("a\n"))
+	now = now.Add(time.Second)
+	_, _ = f.write([]byte("b\n"))
+	_ = f.close()
+
+	a, _ := ioutil.ReadFile(filepath.Join(dir, "app-20261017-15.log"))
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "app-20261017-16.log"))
+	link, _ := os.Readlink(filepath.Join(dir, "app.log"))
+	if string(a) != "a\n" || string(b) != "b\n" || link != "app-20261017-16.log" {
+		t.Errorf("invalid time rotate, %q, %q, %q", a, b, link)
+	}
+}
+
+func TestFileRetention(t *testing.T) {
+	if o := NewChannelOptions(WithCompress(CompressGzip, 1)); o.CompressType != CompressGzip || o.CompressLevel != 1 {
+		t.Errorf("compress options should not be overridden, %+v", o)
+	}
+
+	dir, err := ioutil.TempDir("", "glog_retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 启动时删除过期文件
+	path := filepath.Join(dir, "app.log")
+	old := path + ".5"
+	_ = ioutil.WriteFile(old, []byte("old"), 0644)
+	expired := time.Now().Add(-time.Hour * 48)
+	_ = os.Chtimes(old, expired, expired)
+
+	var mux sync.Mutex
+	var hooked []string
+	c := NewFileChannel(WithFile(path), WithLayout("%m%n"), WithMaxSize(8), WithCompressType(CompressGzip),
+		WithMaxAge(time.Hour*24), WithRotateHook(func(name string) {
+			mux.Lock()
+			hooked = append(hooked, name)
+			mux.Unlock()
+		}))
+	for i := 0; i < 3; i++ {
+		c.Write(&Entry{Text: "1234567"})
+	}
+	_ = c.Close()
+
+	names, _ := filepath.Glob(path + "*")
+	sort.Strings(names)
+	expect := []string{path, path + ".1.gz", path + ".2.gz"}
+	if !reflect.DeepEqual(names, expect) || !reflect.DeepEqual(hooked, []string{path + ".1.gz", path + ".1.gz"}) {
+		t.Errorf("invalid retention, %v, %v", names, hooked)
+	}
+	if r, err := os.Open(path + ".2.gz"); err == nil {
+		zr, _ := gzip.NewReader(r)
+		data, _ := ioutil.ReadAll(zr)
+		r.Close()
+		if string(data) != "1234567\n" {
+			t.Errorf("invalid gzip data, %q", data)
+		}
+	}
+
+	// 超出总大小时从最旧的文件开始删除
+	f, _ := newLogFile(path, NewChannelOptions(WithMaxTotalSize(1)))
+	f.milled = true
+	f.removeExpired()
+	if names, _ := filepath.Glob(path + ".*"); len(names) != 0 {
+		t.Errorf("should remove files over total size, %v", names)
+	}
+
+	// 后台处理期间切割不会等待
+	entered := make(chan struct{}, 4)
+	release := make(chan struct{})
+	f, _ = newLogFile(filepath.Join(dir, "m.log"), NewChannelOptions(WithMaxSize(4), WithCompressType(CompressGzip),
+		WithRotateHook(func(name string) {
+			entered <- struct{}{}
+			<-release
+		})))
+	f.milled = true
+	for i := 0; i < 2; i++ {
+		_, _ = f.write([]byte("abc\n"))
+	}
+	<-entered
+	rotated := make(chan struct{})
+	go func() {
+		_, _ = f.write([]byte("abc\n"))
+		close(rotated)
+	}()
+	select {
+	case <-rotated:
+	case <-time.After(5 * time.Second):
+		t.Error("rotate should not wait for mill")
+	}
+	close(release)
+	<-rotated
+	_ = f.close()
+	f.wait()
+	if names, _ := filepath.Glob(filepath.Join(dir, "m.log.*")); len(names) != 2 {
+		t.Errorf("invalid rotated files, %v", names)
+	}
+
+	// 按时间切割时启动压缩历史文件,跳过当前文件
+	_ = ioutil.WriteFile(filepath.Join(dir, "t-20200101.log"), []byte("old"), 0644)
+	f, _ = newLogFile(filepath.Join(dir, "t-%d{yyyyMMdd}.log"), NewChannelOptions(WithCompressType(CompressGzip)))
+	if err := f.open(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.close()
+	f.wait()
+	if names, _ := filepath.Glob(filepath.Join(dir, "t-*")); len(names) != 2 || !fileExists(filepath.Join(dir, "t-20200101.log.gz")) || !fileExists(f.path) {
+		t.Errorf("invalid pattern retention, %v", names)
+	}
+
+	// 其他文件匹配Glob时不作为历史文件,比如s-%d{yyyyMMdd}.log与s-error-%d{yyyyMMdd}.log
+	today := time.Now().Format("20060102")
+	_ = ioutil.WriteFile(filepath.Join(dir, "s-20200101.log"), []byte("old"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "s-error-"+today+".log"), []byte("e0\n"), 0644)
+	c = NewFileChannel(WithFile(filepath.Join(dir, "s-%d{yyyyMMdd}.log")), WithLayout("%m%n"), WithCompressType(CompressGzip),
+		WithLevelFile(ErrorLevel, filepath.Join(dir, "s-error-%d{yyyyMMdd}.log")))
+	c.Write(&Entry{Level: ErrorLevel, Text: "e1"})
+	_ = c.Close()
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "s-error-"+today+".log")); string(data) != "e0\ne1\n" {
+		t.Errorf("should not compress other files, %q", data)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "s-*.gz")); !reflect.DeepEqual(names, []string{filepath.Join(dir, "s-20200101.log.gz")}) {
+		t.Errorf("invalid pattern retention, %v", names)
+	}
+}
+
+func TestFileBuffered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog_buffered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	c := NewFileChannel(WithFile(path), WithLayout("%m%n"), WithBufferSize(1024), WithFlushInterval(time.Millisecond*20), WithFsyncLevel(ErrorLevel))
+	c.Write(&Entry{Level: InfoLevel, Text: "info"})
+	if data, _ := ioutil.ReadFile(path); len(data) != 0 {
+		t.Errorf("should be buffered, %q", data)
+	}
+	// 达到级别时同步
+	c.Write(&Entry{Level: ErrorLevel, Text: "error"})
+	if data, _ := ioutil.ReadFile(path); string(data) != "info\nerror\n" {
+		t.Errorf("should sync on level, %q", data)
+	}
+	// 定期写入
+	c.Write(&Entry{Level: InfoLevel, Text: "flush"})
+	time.Sleep(time.Millisecond * 100)
+	if data, _ := ioutil.ReadFile(path); string(data) != "info\nerror\nflush\n" {
+		t.Errorf("should flush periodically, %q", data)
+	}
+	c.Write(&Entry{Level: InfoLevel, Text: "close"})
+	if err := c.(Syncer).Sync(); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+	if data, _ := ioutil.ReadFile(path); string(data) != "info\nerror\nflush\nclose\n" {
+		t.Errorf("should flush on close, %q", data)
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.log")
+	c := NewFileChannel(WithFile(path), WithLayout("%m%n"), WithWatchInterval(time.Millisecond*10))
+	defer c.Close()
+	c.Write(&Entry{Text: "a"})
+
+	// 移动后重新打开
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	c.Write(&Entry{Text: "b"})
+	old, _ := ioutil.ReadFile(path + ".old")
+	cur, _ := ioutil.ReadFile(path)
+	if string(old) != "a\n" || string(cur) != "b\n" {
+		t.Errorf("should reopen after move, %q, %q", old, cur)
+	}
+
+	// 删除后重新创建
+	_ = os.Remove(path)
+	time.Sleep(time.Millisecond * 100)
+	c.Write(&Entry{Text: "c"})
+	if cur, _ := ioutil.ReadFile(path); string(cur) != "c\n" {
+		t.Errorf("should recreate after remove, %q", cur)
+	}
+
+	// 截断后更新大小
+	_ = os.Truncate(path, 0)
+	fc := c.(*fileChannel)
+	fc.mux.Lock()
+	f := fc.files.get(path)
+	reopened, err := f.watch()
+	size := f.size
+	fc.mux.Unlock()
+	if reopened || err != nil || size != 0 {
+		t.Errorf("invalid truncate, %v, %v, %v", reopened, err, size)
+	}
+}
+
+func TestFileSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glog_split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewFileChannel(WithFile(filepath.Join(dir, "%x{tenant}", "app.log")), WithLayout("%m%n"),
+		WithLevelFile(ErrorLevel, filepath.Join(dir, "error.log")), WithLevelFile(InfoLevel, filepath.Join(dir, "level-%p.log")), WithMaxOpenFiles(3))
+	write := func(lv Level, tenant, text string) {
+		c.Write(&Entry{Level: lv, Text: text, Fields: []Field{String("tenant", tenant)}})
+	}
+	write(InfoLevel, "a", "a1")
+	write(ErrorLevel, "b", "b1")
+	write(DebugLevel, "../c", "c1")
+	write(InfoLevel, "a", "a2")
+	write(DebugLevel, "", "d1")
+	fc := c.(*fileChannel)
+	if fc.files.lru.Len() != 3 {
+		t.Errorf("should limit open files, %v", fc.files.lru.Len())
+	}
+	// 不依赖Entry的文件不会被关闭,被关闭的文件重新打开时不再处理历史文件
+	if _, ok := fc.files.files[filepath.Join(dir, "error.log")]; !ok || !fc.files.milled[filepath.Join(dir, "a", "app.log")] {
+		t.Errorf("should pin static files and remember milled files, %v", fc.files.milled)
+	}
+	_ = c.Close()
+
+	expect := map[string]string{
+		"a/app.log":       "a1\na2\n",
+		"b/app.log":       "b1\n",
+		".._c/app.log":    "c1\n",
+		"default/app.log": "d1\n",
+		"error.log":       "b1\n",
+		"level-INFO.log":  "a1\na2\n",
+		"level-ERROR.log": "b1\n",
+	}
+	for name, text := range expect {
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(data) != text {
+			t.Errorf("invalid split file %s, %q", name, data)
+		}
+	}
+	if fileExists(filepath.Join(dir, "level-DEBUG.log")) {
+		t.Errorf("should not write debug level file")
+	}
+}
+
+func TestFileShared(t *testing.T) {
+	// 两个Channel使用不同的文件描述符,模拟多个进程,repeat为每个Channel的日志长度
+	run := func(name string, repeat []int, opts ...ChannelOption) {
+		dir, err := ioutil.TempDir("", "glog_shared")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "app.log")
+		const count = 300
+		var wg sync.WaitGroup
+		for i := range repeat {
+			c := NewFileChannel(append([]ChannelOption{WithFile(path), WithLayout("%m%n"), WithShared(), WithMaxSize(1024)}, opts...)...)
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for j := 0; j < count; j++ {
+					c.Write(&Entry{Text: fmt.Sprintf("worker-%d-%04d-%s", id, j, strings.Repeat("x", repeat[id]))})
+				}
+				_ = c.Close()
+			}(i)
+		}
+		wg.Wait()
+
+		names, _ := filepath.Glob(path + "*")
+		seen := make(map[string]bool)
+		for _, name := range names {
+			if strings.HasSuffix(name, ".lock") {
+				continue
+			}
+			data, _ := ioutil.ReadFile(name)
+			if strings.HasSuffix(name, ".gz") {
+				zr, err := gzip.NewReader(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("%s: invalid gzip file %s, %v", name, name, err)
+				}
+				data, _ = ioutil.ReadAll(zr)
+			}
+			if len(data) > 1024+2*100 {
+				t.Errorf("%s: file %s too large, %d", name, name, len(data))
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+				var id, j int
+				if _, err := fmt.Sscanf(line, "worker-%d-%04d-", &id, &j); err != nil || id >= len(repeat) || len(line) != len("worker-0-0000-")+repeat[id] {
+					t.Errorf("%s: invalid line in %s, %q", name, name, line)
+					continue
+				}
+				seen[line[:len("worker-0-0000")]] = true
+			}
+		}
+		if len(seen) != count*len(repeat) {
+			t.Errorf("%s: lost lines, %d", name, len(seen))
+		}
+	}
+
+	run("buffered", []int{40, 40}, WithBufferSize(100))
+	// 日志长度不同时切割的频率不同,切割较少的进程需要及时重新打开,否则会写入被压缩删除的备份
+	run("compress", []int{5, 80}, WithCompressType(CompressGzip))
+	run("buffered compress", []int{5, 80}, WithCompressType(CompressGzip), WithBufferSize(100))
+
+	// 按时间切割时其他进程已经压缩旧文件,缓存的日志写入新文件,不会丢失
+	dir, err := ioutil.TempDir("", "glog_shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	clock := func() time.Time { return now }
+	o := NewChannelOptions(WithShared(), WithBufferSize(100), WithCompressType(CompressGzip))
+	a, _ := newLogFile(filepath.Join(dir, "p-%d{yyyyMMdd}.log"), o)
+	b, _ := newLogFile(filepath.Join(dir, "p-%d{yyyyMMdd}.log"), o)
+	a.now, b.now = clock, clock
+	a.milled, b.milled = true, true
+	_, _ = a.write([]byte("a1\n"))
+	_, _ = b.write([]byte("b1\n"))
+	_ = b.flushLatest()
+	now = now.Add(time.Hour * 24)
+	_, _ = b.write([]byte("b2\n"))
+	b.wait()
+	_, _ = a.write([]byte("a2\n"))
+	_ = a.closeLatest()
+	_ = b.closeLatest()
+	a.wait()
+	var lines []string
+	names, _ := filepath.Glob(filepath.Join(dir, "p-*.log*"))
+	for _, name := range names {
+		data, _ := ioutil.ReadFile(name)
+		if strings.HasSuffix(name, ".gz") {
+			zr, _ := gzip.NewReader(bytes.NewReader(data))
+			data, _ = ioutil.ReadAll(zr)
+		}
+		lines = append(lines, strings.Fields(string(data))...)
+	}
+	sort.Strings(lines)
+	if !reflect.DeepEqual(lines, []string{"a1", "a2", "b1", "b2"}) {
+		t.Errorf("lost lines after period rollover, %v, %v", lines, names)
+	}
+}
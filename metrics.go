@@ -0,0 +1,272 @@
+package glog
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMetrics 默认Logger的统计信息,不会自动注册到expvar,需要时调用expvar.Publish("glog", DefaultMetrics)
+var DefaultMetrics = NewMetrics()
+
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+)
+
+// Counter 只增不减的计数
+type Counter struct {
+	value uint64
+}
+
+func (c *Counter) Inc() {
+	if c != nil {
+		atomic.AddUint64(&c.value, 1)
+	}
+}
+
+func (c *Counter) Add(n uint64) {
+	if c != nil {
+		atomic.AddUint64(&c.value, n)
+	}
+}
+
+func (c *Counter) Value() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge 可增可减的数值
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	if g != nil {
+		atomic.StoreUint64(&g.bits, math.Float64bits(v))
+	}
+}
+
+func (g *Gauge) Value() float64 {
+	if g == nil {
+		return 0
+	}
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	series map[string]*metricSeries // key为格式化后的labels
+}
+
+type metricSeries struct {
+	labels  string // {k="v",...}
+	counter *Counter
+	gauge   *Gauge
+	fn      func() float64
+}
+
+func (s *metricSeries) value() float64 {
+	switch {
+	case s.fn != nil:
+		return s.fn()
+	case s.counter != nil:
+		return float64(s.counter.Value())
+	default:
+		return s.gauge.Value()
+	}
+}
+
+// Metrics 日志系统内部统计,比如各级别日志数,丢弃数,格式化和发送失败数
+// 可通过expvar或者Prometheus文本格式输出,不依赖Prometheus客户端库
+// labels以key,value交替的形式传入
+type Metrics struct {
+	mux      sync.RWMutex
+	families map[string]*metricFamily
+}
+
+// NewMetrics 创建Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+// Counter 获取或者创建Counter,相同name和labels返回同一个Counter
+func (m *Metrics) Counter(name, help string, labels ...string) *Counter {
+	s := m.getSeries(name, help, metricCounter, labels)
+	return s.counter
+}
+
+// Gauge 获取或者创建Gauge
+func (m *Metrics) Gauge(name, help string, labels ...string) *Gauge {
+	s := m.getSeries(name, help, metricGauge, labels)
+	return s.gauge
+}
+
+// CounterFunc 注册由函数计算的Counter,相同name和labels会覆盖之前的注册
+func (m *Metrics) CounterFunc(name, help string, fn func() float64, labels ...string) {
+	s := m.getSeries(name, help, metricCounter, labels)
+	m.mux.Lock()
+	s.fn = fn
+	m.mux.Unlock()
+}
+
+// GaugeFunc 注册由函数计算的Gauge,相同name和labels会覆盖之前的注册
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64, labels ...string) {
+	s := m.getSeries(name, help, metricGauge, labels)
+	m.mux.Lock()
+	s.fn = fn
+	m.mux.Unlock()
+}
+
+func (m *Metrics) getSeries(name, help, kind string, labels []string) *metricSeries {
+	key := formatLabels(labels)
+	m.mux.RLock()
+	if f, ok := m.families[name]; ok {
+		if s, ok := f.series[key]; ok {
+			m.mux.RUnlock()
+			return s
+		}
+	}
+	m.mux.RUnlock()
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, kind: kind, series: make(map[string]*metricSeries)}
+		m.families[name] = f
+	}
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labels: key}
+		if kind == metricCounter {
+			s.counter = &Counter{}
+		} else {
+			s.gauge = &Gauge{}
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// WritePrometheus 以Prometheus文本格式输出
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	buf := NewBuffer()
+	defer buf.Free()
+	m.each(func(f *metricFamily, series []*metricSeries) {
+		buf.AppendString("# HELP ")
+		buf.AppendString(f.name)
+		buf.AppendByte(' ')
+		buf.AppendString(strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(f.help))
+		buf.AppendString("\n# TYPE ")
+		buf.AppendString(f.name)
+		buf.AppendByte(' ')
+		buf.AppendString(f.kind)
+		buf.AppendByte('\n')
+		for _, s := range series {
+			buf.AppendString(f.name)
+			buf.AppendString(s.labels)
+			buf.AppendByte(' ')
+			buf.AppendString(formatMetricValue(s.value()))
+			buf.AppendByte('\n')
+		}
+	})
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP 以Prometheus文本格式输出,可直接注册为/metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
+
+// String 实现expvar.Var,以json格式输出,key为name{labels}
+// glog不引入expvar,避免在http.DefaultServeMux上注册/debug/vars
+func (m *Metrics) String() string {
+	enc := NewJsonEncoder()
+	defer enc.Free()
+	enc.Begin()
+	m.each(func(f *metricFamily, series []*metricSeries) {
+		for _, s := range series {
+			enc.AddFloat64(f.name+s.labels, s.value())
+		}
+	})
+	enc.End()
+	return string(enc.Bytes())
+}
+
+// each 按照名字和labels排序遍历
+func (m *Metrics) each(fn func(f *metricFamily, series []*metricSeries)) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+		series := make([]*metricSeries, 0, len(f.series))
+		for _, s := range f.series {
+			series = append(series, s)
+		}
+		sort.Slice(series, func(i, j int) bool {
+			return series[i].labels < series[j].labels
+		})
+		fn(f, series)
+	}
+}
+
+// formatLabels 转换为{k="v",...}格式,没有label时为空
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	b := strings.Builder{}
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabelValue(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	return strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -12,3 +12,15 @@ func IsTerminal(fd int) bool {
 	_, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), ioctlReadTermios, uintptr(unsafe.Pointer(&termios)), 0, 0, 0)
 	return err == 0
 }
+
+// TerminalWidth 返回终端宽度,失败时返回0
+func TerminalWidth(fd int) int {
+	var ws struct {
+		Row, Col, X, Y uint16
+	}
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if err != 0 {
+		return 0
+	}
+	return int(ws.Col)
+}
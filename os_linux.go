@@ -0,0 +1,5 @@
+package glog
+
+import "syscall"
+
+const ioctlReadTermios = syscall.TCGETS
@@ -13,3 +13,8 @@ func IsTerminal(fd int) bool {
 	r, _, e := syscall.Syscall(procGetConsoleMode.Addr(), 2, uintptr(fd), uintptr(unsafe.Pointer(&st)), 0)
 	return r != 0 && e == 0
 }
+
+// TerminalWidth 返回终端宽度,windows暂不支持,返回0
+func TerminalWidth(fd int) int {
+	return 0
+}
@@ -23,6 +23,8 @@ type Node struct {
 }
 
 func (n *Node) Free() {
+	n.entry = nil
+	n.next = nil
 	gNodePool.Put(n)
 }
 
@@ -82,6 +84,11 @@ func (q *Queue) Iterator() Iter {
 
 func (q *Queue) Push(e *Entry) {
 	e.Obtain()
+	q.append(e)
+}
+
+// append 添加到队尾,不增加引用计数
+func (q *Queue) append(e *Entry) {
 	n := NewNode(e)
 	if q.size == 0 {
 		q.head = n
@@ -93,6 +100,31 @@ func (q *Queue) Push(e *Entry) {
 	q.size++
 }
 
+// RemoveFirst 删除第一个满足条件的Entry,不会释放Entry
+func (q *Queue) RemoveFirst(fn func(e *Entry) bool) *Entry {
+	var prev *Node
+	for n := q.head; n != nil; prev, n = n, n.next {
+		if !fn(n.entry) {
+			continue
+		}
+
+		if prev == nil {
+			q.head = n.next
+		} else {
+			prev.next = n.next
+		}
+		if q.tail == n {
+			q.tail = prev
+		}
+		q.size--
+		e := n.entry
+		n.Free()
+		return e
+	}
+
+	return nil
+}
+
 func (q *Queue) Pop() *Entry {
 	if q.size == 0 {
 		return nil
@@ -0,0 +1,102 @@
+package glog
+
+import "sync/atomic"
+
+const cacheLineSize = 64
+
+type ringSlot struct {
+	seq   uint64
+	entry *Entry
+}
+
+// RingQueue 有界的无锁多生产者单消费者队列
+// 算法参考 http://www.1024cores.net/home/lock-free-algorithms/queues/bounded-mpmc-queue
+// Push可并发调用,Pop和Empty只能在单个消费者中调用
+type RingQueue struct {
+	_     [cacheLineSize]byte
+	tail  uint64 // 生产者写入位置
+	_     [cacheLineSize - 8]byte
+	head  uint64 // 消费者读取位置
+	_     [cacheLineSize - 8]byte
+	count int64 // 已占用的数量,包括正在写入的,保证不超过容量
+	_     [cacheLineSize - 8]byte
+	limit int64
+	mask  uint64
+	slots []ringSlot
+}
+
+// NewRingQueue 创建RingQueue,内部数组向上取整为2的幂,最多保存capacity条
+func NewRingQueue(capacity int) *RingQueue {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	size := 2
+	for size < capacity {
+		size <<= 1
+	}
+
+	q := &RingQueue{limit: int64(capacity), mask: uint64(size - 1), slots: make([]ringSlot, size)}
+	for i := range q.slots {
+		q.slots[i].seq = uint64(i)
+	}
+
+	return q
+}
+
+// Cap 返回队列容量
+func (q *RingQueue) Cap() int {
+	return int(q.limit)
+}
+
+// Push 写入队列,队列满时返回false,不会增加引用计数
+func (q *RingQueue) Push(e *Entry) bool {
+	// 先占用数量,保证队列长度不超过容量
+	if atomic.AddInt64(&q.count, 1) > q.limit {
+		atomic.AddInt64(&q.count, -1)
+		return false
+	}
+	for {
+		pos := atomic.LoadUint64(&q.tail)
+		slot := &q.slots[pos&q.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+		switch dif := int64(seq) - int64(pos); {
+		case dif == 0:
+			if atomic.CompareAndSwapUint64(&q.tail, pos, pos+1) {
+				slot.entry = e
+				atomic.StoreUint64(&slot.seq, pos+1)
+				return true
+			}
+		case dif < 0:
+			// 消费者尚未释放位置
+			atomic.AddInt64(&q.count, -1)
+			return false
+		}
+	}
+}
+
+// Pop 读取队列,为空时返回nil
+func (q *RingQueue) Pop() *Entry {
+	pos := q.head
+	slot := &q.slots[pos&q.mask]
+	if atomic.LoadUint64(&slot.seq) != pos+1 {
+		return nil
+	}
+
+	e := slot.entry
+	slot.entry = nil
+	atomic.StoreUint64(&slot.seq, pos+q.mask+1)
+	atomic.StoreUint64(&q.head, pos+1)
+	atomic.AddInt64(&q.count, -1)
+	return e
+}
+
+// Len 返回队列长度的近似值,包括正在写入的,可在任意goroutine中调用
+func (q *RingQueue) Len() int {
+	return int(atomic.LoadInt64(&q.count))
+}
+
+// Empty 队列是否为空
+func (q *RingQueue) Empty() bool {
+	slot := &q.slots[q.head&q.mask]
+	return atomic.LoadUint64(&slot.seq) != q.head+1
+}
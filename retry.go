@@ -0,0 +1,191 @@
+package glog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 默认的退避参数
+const (
+	DefaultRetryInitial    = time.Millisecond * 100
+	DefaultRetryMaxDelay   = time.Second * 10
+	DefaultRetryMultiplier = 2
+	DefaultRetryJitter     = 0.2
+)
+
+// RetryOptions 失败重试策略,使用带随机抖动的指数退避
+// 只有可重试的错误才会重试,见IsRetryable
+type RetryOptions struct {
+	Max        int           // 最大重试次数,0表示不重试
+	Initial    time.Duration // 首次重试前的等待时间,默认100ms
+	MaxDelay   time.Duration // 单次等待时间上限,包括Retry-After,默认10s
+	Multiplier float64       // 每次等待时间的增长倍数,默认2
+	Jitter     float64       // 随机抖动比例,取值[0,1],默认0.2,负数表示不抖动
+	MaxElapsed time.Duration // 包括重试在内的总耗时上限,0表示不限制
+}
+
+// Do 执行fn,失败且可重试时按照退避策略等待后重试,返回最后一次的错误
+func (o *RetryOptions) Do(fn func() error) error {
+	start := time.Now()
+	delay := o.Initial
+	if delay <= 0 {
+		delay = DefaultRetryInitial
+	}
+
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= o.Max || !IsRetryable(err) {
+			return err
+		}
+
+		wait := o.jitter(delay)
+		if after := RetryAfter(err); after > wait {
+			// 服务端要求的等待时间可能很长,同样不超过MaxDelay
+			wait = after
+			if limit := o.maxDelay(); wait > limit {
+				wait = limit
+			}
+		}
+		if o.MaxElapsed > 0 && time.Since(start)+wait > o.MaxElapsed {
+			return err
+		}
+		time.Sleep(wait)
+		delay = o.next(delay)
+	}
+}
+
+// next 计算下一次的等待时间
+func (o *RetryOptions) next(delay time.Duration) time.Duration {
+	multiplier := o.Multiplier
+	if multiplier <= 1 {
+		multiplier = DefaultRetryMultiplier
+	}
+	delay = time.Duration(float64(delay) * multiplier)
+	if maxDelay := o.maxDelay(); delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (o *RetryOptions) maxDelay() time.Duration {
+	if o.MaxDelay <= 0 {
+		return DefaultRetryMaxDelay
+	}
+	return o.MaxDelay
+}
+
+// jitter 在[delay*(1-jitter), delay*(1+jitter)]中随机取值,避免多个实例同时重试
+func (o *RetryOptions) jitter(delay time.Duration) time.Duration {
+	jitter := o.Jitter
+	switch {
+	case jitter == 0:
+		jitter = DefaultRetryJitter
+	case jitter < 0:
+		return delay
+	case jitter > 1:
+		jitter = 1
+	}
+
+	d := float64(delay) * jitter
+	return delay - time.Duration(d) + time.Duration(rand.Float64()*2*d)
+}
+
+// HTTPError 非2xx的HTTP返回
+type HTTPError struct {
+	StatusCode int           // 状态码
+	Status     string        // 状态,比如503 Service Unavailable
+	Body       string        // 返回内容,只保留开头部分
+	RetryAfter time.Duration // Retry-After头指定的等待时间
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return "http status " + e.Status
+	}
+	return fmt.Sprintf("http status %s: %s", e.Status, e.Body)
+}
+
+// newHTTPError 根据返回创建HTTPError,body为已读取的内容
+func newHTTPError(rsp *http.Response, body []byte) *HTTPError {
+	return &HTTPError{
+		StatusCode: rsp.StatusCode,
+		Status:     rsp.Status,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(rsp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// httpCheck 发送GET请求,非2xx返回HTTPError
+func httpCheck(client *http.Client, url string) error {
+	rsp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 512))
+	_, _ = io.Copy(ioutil.Discard, rsp.Body)
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return newHTTPError(rsp, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// parseRetryAfter 解析Retry-After,支持秒数和HTTP日期两种格式
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// IsRetryable 判断错误是否可以重试
+// 连接错误,超时,429和5xx可以重试,其他错误比如4xx或者格式错误重试也不会成功
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.StatusCode == http.StatusTooManyRequests || he.StatusCode >= 500
+	}
+
+	// url.Error也实现了net.Error,需要判断内部的错误
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryAfter 返回服务端要求的最短等待时间,没有则返回0
+func RetryAfter(err error) time.Duration {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.RetryAfter
+	}
+	return 0
+}
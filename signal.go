@@ -0,0 +1,156 @@
+package glog
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SignalOptions HandleSignals配置,每项功能可以单独开启
+type SignalOptions struct {
+	Shutdown      bool          // SIGTERM/SIGINT时刷新并关闭所有Channel
+	Timeout       time.Duration // 关闭时最长等待时间,默认DefaultStopTimeout
+	Exit          bool          // 关闭后调用os.Exit(ExitCode)退出,否则重新发送信号,由系统默认方式结束进程
+	ExitCode      int           // 退出码
+	Reopen        bool          // SIGHUP时重新打开文件,用于配合logrotate
+	LevelSwitch   bool          // SIGUSR1时提高一级日志详细程度(比如Info->Debug),SIGUSR2时降低一级,windows不支持
+	LevelDuration time.Duration // 修改日志级别后,经过多长时间恢复,0表示不恢复
+}
+
+// HandleSignals 监听信号并处理,返回的函数用于停止监听
+func HandleSignals(l Logger, o SignalOptions) func() {
+	h := newSignalHandler(l, o)
+	sigs := h.signals()
+	if len(sigs) == 0 {
+		return func() {}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	stop := h.run(ch)
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			stop()
+		})
+	}
+}
+
+func newSignalHandler(l Logger, o SignalOptions) *signalHandler {
+	h := &signalHandler{logger: l, opts: o, exit: exitSignal}
+	if h.opts.Timeout <= 0 {
+		h.opts.Timeout = DefaultStopTimeout
+	}
+	if h.opts.Exit {
+		h.exit = func(os.Signal) { os.Exit(h.opts.ExitCode) }
+	}
+	return h
+}
+
+type signalHandler struct {
+	logger   Logger
+	opts     SignalOptions
+	exit     func(sig os.Signal) // 关闭Logger后结束进程
+	mux      sync.Mutex
+	original Level       // 修改前的日志级别
+	changed  bool        // 是否修改过日志级别
+	timer    *time.Timer // 用于恢复日志级别
+}
+
+// signals 返回需要监听的信号
+func (h *signalHandler) signals() []os.Signal {
+	var sigs []os.Signal
+	if h.opts.Shutdown {
+		sigs = append(sigs, syscall.SIGTERM, syscall.SIGINT)
+	}
+	if h.opts.Reopen {
+		sigs = append(sigs, syscall.SIGHUP)
+	}
+	if h.opts.LevelSwitch && sigLevelUp != nil {
+		sigs = append(sigs, sigLevelUp, sigLevelDown)
+	}
+	return sigs
+}
+
+// run 在后台处理ch中的信号,返回的函数用于停止处理
+func (h *signalHandler) run(ch <-chan os.Signal) func() {
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				h.handle(sig)
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() { close(quit) }
+}
+
+func (h *signalHandler) handle(sig os.Signal) {
+	switch sig {
+	case syscall.SIGTERM, syscall.SIGINT:
+		h.shutdown(sig)
+	case syscall.SIGHUP:
+		if r, ok := h.logger.(Reopener); ok {
+			_ = r.Reopen()
+		}
+	case sigLevelUp:
+		h.switchLevel(1)
+	case sigLevelDown:
+		h.switchLevel(-1)
+	}
+}
+
+// shutdown 刷新并关闭Logger,之后退出进程
+func (h *signalHandler) shutdown(sig os.Signal) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	_ = h.logger.StopContext(ctx)
+	cancel()
+	h.exit(sig)
+}
+
+// exitSignal 恢复信号的默认处理并重新发送,由系统默认方式结束进程
+func exitSignal(sig os.Signal) {
+	signal.Reset(sig)
+	raiseSignal(sig)
+}
+
+// switchLevel 修改全局日志级别,delta大于0时输出更详细
+func (h *signalHandler) switchLevel(delta int) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	lv := h.logger.GetLevel("")
+	if !h.changed {
+		h.original = lv
+		h.changed = true
+	}
+
+	next := lv + Level(delta)
+	if next < PanicLevel || next > TraceLevel {
+		return
+	}
+	h.logger.SetLevel("", next)
+
+	if h.opts.LevelDuration > 0 {
+		if h.timer != nil {
+			h.timer.Stop()
+		}
+		h.timer = time.AfterFunc(h.opts.LevelDuration, h.restoreLevel)
+	}
+}
+
+// restoreLevel 恢复修改前的日志级别
+func (h *signalHandler) restoreLevel() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.changed {
+		h.logger.SetLevel("", h.original)
+		h.changed = false
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package glog
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	sigLevelUp   os.Signal = syscall.SIGUSR1
+	sigLevelDown os.Signal = syscall.SIGUSR2
+)
+
+// raiseSignal 重新发送信号给自身
+func raiseSignal(sig os.Signal) {
+	if s, ok := sig.(syscall.Signal); ok {
+		_ = syscall.Kill(os.Getpid(), s)
+	}
+}
@@ -0,0 +1,14 @@
+package glog
+
+import "os"
+
+// windows不支持SIGUSR1和SIGUSR2
+var (
+	sigLevelUp   os.Signal
+	sigLevelDown os.Signal
+)
+
+// raiseSignal windows不支持向自身发送信号,直接退出
+func raiseSignal(sig os.Signal) {
+	os.Exit(1)
+}
@@ -7,23 +7,31 @@ import (
 	"strings"
 )
 
+// maxCallers getFrame支持的最大堆栈深度
+const maxCallers = 32
+
 // getFrame 获取调用函数信息
-func getFrame(skipFrames int) *runtime.Frame {
+func getFrame(skipFrames int) runtime.Frame {
 	// We need the frame at index skipFrames+2, since we never want runtime.Callers and getFrame
 	targetFrameIndex := skipFrames + 2
 
 	// Set size to targetFrameIndex+2 to ensure we have room for one more caller than we need
-	programCounters := make([]uintptr, targetFrameIndex+2)
-	n := runtime.Callers(0, programCounters)
+	// use a fixed array on the stack to avoid allocation
+	var programCounters [maxCallers]uintptr
+	size := targetFrameIndex + 2
+	if size > maxCallers {
+		size = maxCallers
+	}
+	n := runtime.Callers(0, programCounters[:size])
 
-	frame := &runtime.Frame{Function: "unknown"}
+	frame := runtime.Frame{Function: "unknown"}
 	if n > 0 {
 		frames := runtime.CallersFrames(programCounters[:n])
 		for more, frameIndex := true, 0; more && frameIndex <= targetFrameIndex; frameIndex++ {
 			var frameCandidate runtime.Frame
 			frameCandidate, more = frames.Next()
 			if frameIndex == targetFrameIndex {
-				*frame = frameCandidate
+				frame = frameCandidate
 			}
 		}
 	}